@@ -58,7 +58,13 @@ func main() {
 	// Crawl the web breadth-first,
 	// starting from the command-line arguments.
 	// 当所有发现的链接都已经被访问或电脑的内存耗尽时，程序运行结束。
-	breadthFirst(crawl, os.Args[1:])
+	// The URLs are canonical, like those that links.Extract returns,
+	// so that seen can recognize them.
+	seeds, err := links.CanonicalAll(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	breadthFirst(crawl, seeds)
 }
 
 //!-main
@@ -0,0 +1,196 @@
+package links
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Canonical returns the canonical form of a URL, so that URLs that
+// name the same page are equal: its scheme and host are lower case,
+// it has no default port and no fragment, its path is free of . and
+// .. segments, and escapes only what it must.  Extract and Parse
+// return canonical URLs.
+func Canonical(rawurl string) (string, error) {
+	return (&Canonicalizer{}).Canonical(rawurl)
+}
+
+// CanonicalAll returns the canonical forms of urls, in order.
+// It fails if any of them cannot be parsed.
+func CanonicalAll(urls []string) ([]string, error) {
+	list := make([]string, len(urls))
+	for i, url := range urls {
+		var err error
+		if list[i], err = Canonical(url); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// A Canonicalizer puts URLs into canonical form, and optionally
+// rewrites their queries too.
+type Canonicalizer struct {
+	SortQuery     bool // sort the query parameters by name
+	StripTracking bool // remove tracking parameters, such as utm_source
+}
+
+// tracking are the tracking parameters other than utm_*.
+var tracking = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"igshid":  true,
+	"yclid":   true,
+}
+
+// defaultPorts are the default ports of the schemes that have them.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// Canonical returns the canonical form of a URL.
+func (c *Canonicalizer) Canonical(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	c.canonicalize(u)
+	return u.String(), nil
+}
+
+// canonicalize puts u into canonical form.
+func (c *Canonicalizer) canonicalize(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Host != "" {
+		host, port := strings.ToLower(u.Hostname()), u.Port()
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		if port != "" && port != defaultPorts[u.Scheme] {
+			host += ":" + port
+		}
+		u.Host = host
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	if u.Opaque == "" {
+		p := removeDots(normalizeEscapes(u.EscapedPath()))
+		if p == "" && u.Host != "" {
+			p = "/"
+		}
+		u.Path, _ = url.PathUnescape(p)
+		u.RawPath = p
+	}
+
+	u.ForceQuery = false
+	if u.RawQuery != "" {
+		var params []string
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			if param == "" || (c.StripTracking && isTracking(name(param))) {
+				continue
+			}
+			params = append(params, normalizeEscapes(param))
+		}
+		if c.SortQuery {
+			sort.SliceStable(params, func(i, j int) bool { return name(params[i]) < name(params[j]) })
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+}
+
+// name returns the unescaped name of a query parameter, name=value.
+func name(param string) string {
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		param = param[:i]
+	}
+	if s, err := url.QueryUnescape(param); err == nil {
+		return s
+	}
+	return param
+}
+
+func isTracking(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || tracking[name]
+}
+
+// removeDots removes the . and .. segments of an absolute path, as in
+// RFC 3986, section 5.2.4.
+func removeDots(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+	var out []string
+	segments := strings.Split(p[1:], "/")
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+			continue
+		}
+		if last {
+			out = append(out, "") // keep the final slash
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// normalizeEscapes decodes the escapes of unreserved characters in s,
+// and makes the hex digits of the others upper case.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			c := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(c) {
+				b.WriteByte(c)
+			} else {
+				b.WriteString(strings.ToUpper(s[i : i+3]))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// isUnreserved reports whether c is an unreserved character of
+// RFC 3986, which never needs escaping.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,73 @@
+package links
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	for _, test := range []struct {
+		url, want string
+	}{
+		{"http://a/x", "http://a/x"},
+		{"http://a/x#frag", "http://a/x"},
+		{"HTTP://A:80/x", "http://a/x"},
+		{"http://a/x?", "http://a/x"},
+		{"https://Example.COM:443", "https://example.com/"},
+		{"https://example.com:8443/", "https://example.com:8443/"},
+		{"http://example.com:/", "http://example.com/"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"http://[::1]:8080/", "http://[::1]:8080/"},
+		{"http://a/b/c/./../../g", "http://a/g"},
+		{"http://a/b/./c/", "http://a/b/c/"},
+		{"http://a/b/c/..", "http://a/b/"},
+		{"http://a/../../x", "http://a/x"},
+		{"http://a//b", "http://a//b"},
+		{"http://a/%7euser/%2E%2E/x", "http://a/x"},
+		{"http://a/%7Euser/a%2fb%3f", "http://a/~user/a%2Fb%3F"},
+		{"http://a/x?b=2&a=1&&utm_source=feed#top", "http://a/x?b=2&a=1&utm_source=feed"},
+		{"http://user:pw@A/", "http://user:pw@a/"},
+		{"mailto:Gopher@Example.com", "mailto:Gopher@Example.com"},
+	} {
+		got, err := Canonical(test.url)
+		if err != nil {
+			t.Errorf("Canonical(%q): %v", test.url, err)
+		} else if got != test.want {
+			t.Errorf("Canonical(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+
+	c := &Canonicalizer{SortQuery: true, StripTracking: true}
+	for _, test := range []struct {
+		url, want string
+	}{
+		{"http://a/x?b=2&a=1&utm_source=feed&UTM_Medium=rss#top", "http://a/x?a=1&b=2"},
+		{"http://a/x?q=go&gclid=123&fbclid=456&q=gopl", "http://a/x?q=go&q=gopl"},
+		{"http://a/x?utm_campaign=spring", "http://a/x"},
+		{"http://a/x?z&y=%7e", "http://a/x?y=~&z"},
+	} {
+		got, err := c.Canonical(test.url)
+		if err != nil {
+			t.Errorf("Canonical(%q): %v", test.url, err)
+		} else if got != test.want {
+			t.Errorf("Canonical(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+
+	if _, err := Canonical("http://a b/"); err == nil {
+		t.Errorf("Canonical of a bad URL succeeded")
+	}
+}
+
+func TestCanonicalAll(t *testing.T) {
+	got, err := CanonicalAll([]string{"HTTP://A/x#frag", "http://b:80/./y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://a/x", "http://b/y"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("CanonicalAll = %q, want %q", got, want)
+	}
+	if got, err := CanonicalAll([]string{"http://a/", "http://[::1"}); err == nil {
+		t.Errorf("CanonicalAll with a bad URL = %q, want error", got)
+	}
+}
@@ -0,0 +1,152 @@
+package links
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// A Kind is a kind of link, or a set of them.
+type Kind uint
+
+const (
+	Anchor        Kind = 1 << iota // <a href> and <area href>
+	Stylesheet                     // <link rel=stylesheet href>
+	Script                         // <script src>
+	Image                          // <img src>, and <img srcset> and <source srcset>
+	CanonicalLink                  // <link rel=canonical href>
+	Frame                          // <iframe src> and <frame src>
+	Refresh                        // <meta http-equiv=refresh content>
+
+	AllKinds = Anchor | Stylesheet | Script | Image | CanonicalLink | Frame | Refresh
+)
+
+var kindNames = []string{"anchor", "stylesheet", "script", "image", "canonical", "frame", "refresh"}
+
+func (k Kind) String() string {
+	var names []string
+	for i, name := range kindNames {
+		if k&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("Kind(%d)", uint(k))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseKind returns the set of kinds named, as a list separated by
+// commas, in s.  "all" names all of them.
+func ParseKind(s string) (Kind, error) {
+	var k Kind
+outer:
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			k |= AllKinds
+			continue
+		}
+		for i, n := range kindNames {
+			if name == n {
+				k |= 1 << i
+				continue outer
+			}
+		}
+		return 0, fmt.Errorf("unknown kind of link %q", name)
+	}
+	return k, nil
+}
+
+// A Link is a link in an HTML document.
+type Link struct {
+	URL      string // resolved, and in canonical form
+	Kind     Kind
+	Tag      string // the element, such as "img"
+	Attr     string // the attribute, such as "srcset"
+	NoFollow bool   // marked rel=nofollow, or on a page marked nofollow
+}
+
+// Options select the links that ExtractLinks and ParseLinks return.
+type Options struct {
+	Kinds         Kind           // the kinds of link; 0 means Anchor
+	NoFollow      bool           // leave out the links marked nofollow
+	Canonicalizer *Canonicalizer // nil means the canonical form of Canonical
+}
+
+// attr returns the value of the attribute key of n, and whether it has it.
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasToken reports whether the space-separated list s contains token,
+// ignoring case.  Commas separate too, as in robots meta tags.
+func hasToken(s, token string) bool {
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// srcset returns the URLs of the image candidates of a srcset
+// attribute, such as "small.jpg 480w, large.jpg 1080w".
+func srcset(s string) []string {
+	var refs []string
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return refs
+		}
+		// The URL runs to the next white space.  Commas at its end
+		// end the candidate; otherwise descriptors follow, to a comma.
+		i := strings.IndexAny(s, " \t\n\r\f")
+		if i < 0 {
+			i = len(s)
+		}
+		ref := s[:i]
+		s = s[i:]
+		if strings.HasSuffix(ref, ",") {
+			ref = strings.TrimRight(ref, ",")
+		} else if j := strings.IndexByte(s, ','); j >= 0 {
+			s = s[j+1:]
+		} else {
+			s = ""
+		}
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+}
+
+// refresh returns the URL of the content of a refresh <meta> tag, such
+// as "5; url=/next.html".
+func refresh(content string) (string, bool) {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return "", false // a refresh of the page itself
+	}
+	ref := strings.TrimSpace(content[i+1:])
+	if len(ref) >= 3 && strings.EqualFold(ref[:3], "url") {
+		rest := strings.TrimSpace(ref[3:])
+		if !strings.HasPrefix(rest, "=") {
+			return "", false
+		}
+		ref = strings.TrimSpace(rest[1:])
+	}
+	if len(ref) > 0 && (ref[0] == '\'' || ref[0] == '"') {
+		if j := strings.IndexByte(ref[1:], ref[0]); j >= 0 {
+			ref = ref[1 : j+1]
+		} else {
+			ref = ref[1:]
+		}
+	}
+	return ref, ref != ""
+}
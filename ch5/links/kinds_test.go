@@ -0,0 +1,140 @@
+package links
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const page = `<html><head>
+<base href="/docs/">
+<link rel="stylesheet" href="style.css">
+<link rel="canonical" href="https://Example.com/docs/index.html">
+<link rel="icon" href="favicon.ico">
+<meta http-equiv="Refresh" content="30; URL='next.html'">
+<script src="app.js"></script>
+<script>var inline;</script>
+</head><body>
+<a href="intro.html">intro</a>
+<a href="http://ads.example/" rel="sponsored nofollow">ad</a>
+<img src="logo.png" srcset="logo-2x.png 2x, logo,3x.png 3x">
+<picture><source srcset="wide.webp 1000w,narrow.webp"><img src="fallback.jpg"></picture>
+<iframe src="//video.example/embed"></iframe>
+<map><area href="#top"></map>
+</body></html>`
+
+func TestParseLinks(t *testing.T) {
+	base, _ := url.Parse("http://example.com/index.html")
+	links, err := ParseLinks(base, strings.NewReader(page), &Options{Kinds: AllKinds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range links {
+		got = append(got, fmt.Sprintf("%s %s[%s] %s %t", l.Kind, l.Tag, l.Attr, l.URL, l.NoFollow))
+	}
+	want := []string{
+		"stylesheet link[href] http://example.com/docs/style.css false",
+		"canonical link[href] https://example.com/docs/index.html false",
+		"refresh meta[content] http://example.com/docs/next.html false",
+		"script script[src] http://example.com/docs/app.js false",
+		"anchor a[href] http://example.com/docs/intro.html false",
+		"anchor a[href] http://ads.example/ true",
+		"image img[src] http://example.com/docs/logo.png false",
+		"image img[srcset] http://example.com/docs/logo-2x.png false",
+		"image img[srcset] http://example.com/docs/logo,3x.png false",
+		"image source[srcset] http://example.com/docs/wide.webp false",
+		"image source[srcset] http://example.com/docs/narrow.webp false",
+		"image img[src] http://example.com/docs/fallback.jpg false",
+		"frame iframe[src] http://video.example/embed false",
+		"anchor area[href] http://example.com/docs/ false",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ParseLinks returned\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// By default, only anchors; with NoFollow, not those marked nofollow.
+	for _, test := range []struct {
+		opts *Options
+		want string
+	}{
+		{nil, "http://example.com/docs/intro.html http://ads.example/ http://example.com/docs/"},
+		{&Options{NoFollow: true}, "http://example.com/docs/intro.html http://example.com/docs/"},
+		{&Options{Kinds: Script | Frame}, "http://example.com/docs/app.js http://video.example/embed"},
+	} {
+		links, err := ParseLinks(base, strings.NewReader(page), test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(urls(links), " "); got != test.want {
+			t.Errorf("ParseLinks(%+v) = %s, want %s", test.opts, got, test.want)
+		}
+	}
+
+	// A robots meta tag marks all the links of a page.
+	doc := `<meta name="robots" content="noindex, nofollow"><a href="a.html">a</a>`
+	links, err = ParseLinks(base, strings.NewReader(doc), &Options{NoFollow: true})
+	if err != nil || len(links) != 0 {
+		t.Errorf("ParseLinks of a nofollow page = %v, %v", links, err)
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	for _, test := range []struct {
+		srcset, want string
+	}{
+		{"a.png", "a.png"},
+		{"a.png 1x, b.png 2x", "a.png b.png"},
+		{"a.png,b.png", "a.png,b.png"}, // commas inside a URL belong to it
+		{"a.png,, b.png", "a.png b.png"},
+		{" a.png 480w,\n\tb,c.png 800w , ", "a.png b,c.png"},
+		{"a.png 1x,, b.png", "a.png b.png"},
+		{"", ""},
+	} {
+		if got := strings.Join(srcset(test.srcset), " "); got != test.want {
+			t.Errorf("srcset(%q) = %q, want %q", test.srcset, got, test.want)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	for _, test := range []struct {
+		content, want string
+	}{
+		{"5; url=next.html", "next.html"},
+		{"0;URL='http://example.com/'", "http://example.com/"},
+		{`0; url = "a b.html"`, "a b.html"},
+		{"3, next.html", "next.html"},
+		{"10", ""},
+		{"0; urn=x", "urn=x"},
+		{"0; url", ""},
+	} {
+		got, ok := refresh(test.content)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("refresh(%q) = %q, %t, want %q", test.content, got, ok, test.want)
+		}
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want Kind
+	}{
+		{"anchor", Anchor},
+		{"anchor, Frame,refresh", Anchor | Frame | Refresh},
+		{"all", AllKinds},
+	} {
+		got, err := ParseKind(test.s)
+		if err != nil || got != test.want {
+			t.Errorf("ParseKind(%q) = %v, %v, want %v", test.s, got, err, test.want)
+		}
+	}
+	if _, err := ParseKind("anchor,video"); err == nil {
+		t.Errorf("ParseKind(video) succeeded")
+	}
+	if s := (Image | Anchor).String(); s != "anchor,image" {
+		t.Errorf("String() = %q", s)
+	}
+}
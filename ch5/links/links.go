@@ -14,7 +14,10 @@ package links
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"golang.org/x/net/html"
 )
@@ -23,6 +26,16 @@ import (
 // the response as HTML, and returns the links in the HTML document.
 // 导出函数（Public）
 func Extract(url string) ([]string, error) {
+	links, err := ExtractLinks(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return urls(links), nil
+}
+
+// ExtractLinks is like Extract, but returns the links that opts
+// selects, with their kinds.
+func ExtractLinks(url string, opts *Options) ([]Link, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
@@ -32,26 +45,125 @@ func Extract(url string) ([]string, error) {
 		return nil, fmt.Errorf("getting %s: %s", url, resp.Status)
 	}
 
-	doc, err := html.Parse(resp.Body)
+	links, err := ParseLinks(resp.Request.URL, resp.Body, opts)
 	resp.Body.Close()
 	if err != nil {
 		return nil, fmt.Errorf("parsing %s as HTML: %v", url, err)
 	}
+	return links, nil
+}
 
-	var links []string
-	visitNode := func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			for _, a := range n.Attr {
-				if a.Key != "href" {
-					continue
+// Parse parses an HTML document from r, and returns its links,
+// resolved relative to base, the URL of the document, in canonical form.
+func Parse(base *url.URL, r io.Reader) ([]string, error) {
+	links, err := ParseLinks(base, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return urls(links), nil
+}
+
+// ParseLinks is like Parse, but returns the links that opts selects,
+// with their kinds.  A <base href> element changes base.
+func ParseLinks(base *url.URL, r io.Reader, opts *Options) ([]Link, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	kinds := opts.Kinds
+	if kinds == 0 {
+		kinds = Anchor
+	}
+	canon := opts.Canonicalizer
+	if canon == nil {
+		canon = &Canonicalizer{}
+	}
+
+	// The first <base href> sets the base of the whole document, and a
+	// robots <meta> tag may forbid following any of its links.
+	haveBase, noFollowAll := false, false
+	forEachNode(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.Data {
+		case "base":
+			if href, ok := attr(n, "href"); ok && !haveBase {
+				if b, err := base.Parse(strings.TrimSpace(href)); err == nil {
+					base, haveBase = b, true
 				}
-				// 现在links中存储的不是href属性的原始值，而是通过resp.Request.URL解析后的值。
-				// 解析后，这些连接以绝对路径的形式存在，可以直接被http.Get访问。
-				link, err := resp.Request.URL.Parse(a.Val)
-				if err != nil {
-					continue // ignore bad URLs
+			}
+		case "meta":
+			name, _ := attr(n, "name")
+			content, _ := attr(n, "content")
+			if strings.EqualFold(name, "robots") && hasToken(content, "nofollow") {
+				noFollowAll = true
+			}
+		}
+	}, nil)
+
+	var links []Link
+	add := func(kind Kind, n *html.Node, key, ref string) {
+		if kinds&kind == 0 {
+			return
+		}
+		rel, _ := attr(n, "rel")
+		noFollow := noFollowAll || hasToken(rel, "nofollow")
+		if noFollow && opts.NoFollow {
+			return
+		}
+		// 现在links中存储的不是href属性的原始值，而是通过base解析后的值。
+		// 解析后，这些连接以绝对路径的形式存在，可以直接被http.Get访问。
+		link, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return // ignore bad URLs
+		}
+		// 规范化之后，同一个页面的不同写法（大小写、默认端口、#片段等）会得到同一个URL。
+		canon.canonicalize(link)
+		links = append(links, Link{link.String(), kind, n.Data, key, noFollow})
+	}
+	visitNode := func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		// addAttr adds the link in the attribute key of n, if n has it.
+		addAttr := func(kind Kind, key string) {
+			if ref, ok := attr(n, key); ok {
+				add(kind, n, key, ref)
+			}
+		}
+		switch n.Data {
+		case "a", "area":
+			addAttr(Anchor, "href")
+		case "link":
+			rel, _ := attr(n, "rel")
+			if hasToken(rel, "stylesheet") {
+				addAttr(Stylesheet, "href")
+			}
+			if hasToken(rel, "canonical") {
+				addAttr(CanonicalLink, "href")
+			}
+		case "script":
+			addAttr(Script, "src")
+		case "img":
+			addAttr(Image, "src")
+			fallthrough
+		case "source":
+			if set, ok := attr(n, "srcset"); ok {
+				for _, ref := range srcset(set) {
+					add(Image, n, "srcset", ref)
 				}
-				links = append(links, link.String())
+			}
+		case "iframe", "frame":
+			addAttr(Frame, "src")
+		case "meta":
+			equiv, _ := attr(n, "http-equiv")
+			content, _ := attr(n, "content")
+			if ref, ok := refresh(content); ok && strings.EqualFold(equiv, "refresh") {
+				add(Refresh, n, "content", ref)
 			}
 		}
 	}
@@ -59,6 +171,15 @@ func Extract(url string) ([]string, error) {
 	return links, nil
 }
 
+// urls returns the URLs of links.
+func urls(links []Link) []string {
+	var list []string
+	for _, link := range links {
+		list = append(list, link.URL)
+	}
+	return list
+}
+
 //!-Extract
 
 // Copied from gopl.io/ch5/outline2.
@@ -0,0 +1,32 @@
+package links
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("http://Example.com:80/dir/page.html")
+	doc := `<html><body>
+<a href="other.html#section">relative</a>
+<a href="../up/./x">dots</a>
+<a href="HTTP://EXAMPLE.COM/">absolute</a>
+<a href="?">empty query</a>
+<a name="anchor">no href</a>
+<a href="http://[bad">bad</a>
+</body></html>`
+	got, err := Parse(base, strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"http://example.com/dir/other.html",
+		"http://example.com/up/x",
+		"http://example.com/",
+		"http://example.com/dir/page.html",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Parse returned\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts holds the registered users of a server and the bcrypt
+// hashes of their passwords.  The nickname of each registered user is
+// reserved for that user.
+//
+// An accounts file has one line for each user, the name and the hash
+// separated by a colon, as printed by HashPassword.  Blank lines and
+// lines that begin with # are ignored.
+type Accounts struct {
+	hashes map[string][]byte
+}
+
+// ReadAccounts reads an accounts file from r.
+func ReadAccounts(r io.Reader) (*Accounts, error) {
+	a := &Accounts{hashes: make(map[string][]byte)}
+	input := bufio.NewScanner(r)
+	for n := 1; input.Scan(); n++ {
+		line := strings.TrimSpace(input.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing colon", n)
+		}
+		name, hash := line[:i], []byte(line[i+1:])
+		if err := checkName(name); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		a.hashes[name] = hash
+	}
+	if err := input.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadAccounts reads the named accounts file.
+func LoadAccounts(filename string) (*Accounts, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := ReadAccounts(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return a, nil
+}
+
+// HashPassword returns the line of an accounts file for name.
+func HashPassword(name, password string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return name + ":" + string(hash), nil
+}
+
+// Has reports whether name is registered.  A nil *Accounts has no users.
+func (a *Accounts) Has(name string) bool {
+	return a != nil && a.hashes[name] != nil
+}
+
+// dummyHash is compared with the passwords of unknown users, so that
+// they take as long to reject as wrong passwords do.
+const dummyHash = "$2a$10$9jKF6TMmvAdH9zgs98cnkukT.mCsKDo1F2o/DE.cYI7DXwM/qdo0W"
+
+// Check reports whether password is that of the user name.
+// It is slow, by design.
+func (a *Accounts) Check(name, password string) bool {
+	hash := []byte(dummyHash)
+	if a.Has(name) {
+		hash = a.hashes[name]
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	return err == nil && a.Has(name)
+}
@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accounts returns Accounts for the users in passwords.  It hashes at
+// the minimum cost, to keep the tests fast.
+func accounts(t *testing.T, passwords map[string]string) *Accounts {
+	t.Helper()
+	var file strings.Builder
+	for name, password := range passwords {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(name + ":" + string(hash) + "\n")
+	}
+	a, err := ReadAccounts(strings.NewReader(file.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// selfSigned returns a TLS certificate for 127.0.0.1, and a pool of
+// roots that trusts it.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"chat test"}},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+// startTLS is like start, but serves s over TLS, and returns the
+// configuration with which clients trust it.
+func startTLS(t *testing.T, s *Server) (string, *tls.Config) {
+	t.Helper()
+	cert, roots := selfSigned(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, s, tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}}))
+	return listener.Addr().String(), &tls.Config{RootCAs: roots}
+}
+
+// dialTLS connects a client to addr over TLS, without naming it.
+func dialTLS(t *testing.T, addr string, config *tls.Config) *testClient {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t, "", conn, bufio.NewScanner(conn)}
+}
+
+func TestLoginRequired(t *testing.T) {
+	s := &Server{
+		Accounts:      accounts(t, map[string]string{"wendy": "s3cret", "xavier": "hunter2"}),
+		LoginRequired: true,
+	}
+	addr, config := startTLS(t, s)
+
+	wendy := dialTLS(t, addr, config)
+	wendy.expect("Log in with /login name password")
+	wendy.send("hello?")
+	wendy.expect("error: log in first")
+	wendy.send("/login wendy guess")
+	wendy.expect("error: login incorrect")
+	wendy.send("/login wendy s3cret")
+	wendy.expect("wendy has joined #lobby")
+
+	// A second session of the same user is refused.
+	again := dialTLS(t, addr, config)
+	again.send("/login wendy s3cret")
+	again.expect("error: wendy is already logged in")
+
+	// Three failures and you are out.
+	mallory := dialTLS(t, addr, config)
+	for i := 0; i < 3; i++ {
+		mallory.send("/login xavier password")
+	}
+	mallory.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for mallory.input.Scan() {
+		if strings.Contains(mallory.input.Text(), "has joined") {
+			t.Errorf("mallory got in: %q", mallory.input.Text())
+		}
+	}
+	if err := mallory.input.Err(); err != nil {
+		t.Errorf("connection not closed after failed logins: %v", err)
+	}
+}
+
+func TestReservedNick(t *testing.T) {
+	s := &Server{Accounts: accounts(t, map[string]string{"yara": "pa55"})}
+	addr := start(t, s)
+
+	guest := dial(t, addr, "guest")
+	guest.send("/nick yara")
+	guest.expect("error: yara is reserved")
+
+	yara := dial(t, addr, "zed")
+	yara.send("/login yara wrong")
+	yara.expect("error: login incorrect")
+	yara.send("/login yara pa55")
+	guest.expect("zed is now known as yara")
+	yara.expect("logged in as yara")
+	yara.send("/nick zed")
+	yara.expect("yara is now known as zed")
+	yara.send("/nick yara")
+	yara.expect("zed is now known as yara")
+}
+
+func TestReadAccounts(t *testing.T) {
+	for _, bad := range []string{
+		"alice\n",
+		"alice:notahash\n",
+		"al ice:$2a$04$aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n",
+	} {
+		if _, err := ReadAccounts(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadAccounts(%q) succeeded", bad)
+		}
+	}
+
+	line, err := HashPassword("alice", "wonderland")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ReadAccounts(strings.NewReader("# users\n\n" + line + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Check("alice", "wonderland") || a.Check("alice", "looking-glass") || a.Check("bob", "") {
+		t.Errorf("Check gives wrong answers")
+	}
+}
@@ -4,45 +4,495 @@
 // See page 254.
 //!+
 
-// Chat is a server that lets clients chat with each other.
-package main
+// Package chat provides a server that lets clients chat with each other.
+// See main.go for the chat command.
+//
+// Each client is in one room at a time, starting in #lobby, and what
+// it says is delivered to the clients in the same room.  Lines that
+// begin with a slash are commands:
+//
+//	/nick name        change nickname
+//	/join #room       leave the current room and enter another
+//	/part             leave the current room for #lobby
+//	/who              list the clients in the current room
+//	/msg name text    send text to one client only
+//	/history [n]      show the last n lines said in the current room
+//	/login name pass  log in as a registered user
+//	/quit             disconnect
+//
+// Operators, who are registered users, have two more commands, which
+// take a nickname or an address:
+//
+//	/kick who [why]   disconnect a client, or all clients at an address
+//	/ban who [why]    kick and refuse the address of a client, until restart
+//
+// The nicknames of the users registered in Accounts are reserved for
+// them.  If LoginRequired, clients must log in before anything else.
+// For TLS, serve a listener made by crypto/tls.
+//
+// Each client may send Rate lines a second, in bursts of up to Burst
+// lines, of up to MaxLine bytes each.  The lines that break the limits
+// are refused, and a client that breaks them Strikes times in a row is
+// muted for the Mute duration, with a notice to its room.
+//
+// The last History lines said in each room are replayed to the
+// clients that enter it.  With a Transcript, they are also recorded,
+// with the time and the room.
+//
+// The Handler of a Server serves a web page from which browsers join
+// the same rooms over WebSocket connections.
+//
+// Each client has an outgoing queue of bounded length, so that a
+// client that stops reading cannot block the others.  When its queue
+// is full, messages to the client are dropped or, with the Disconnect
+// policy, the client is disconnected.  Clients that say nothing for
+// the Idle duration are disconnected too.
+package chat
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-//!+broadcaster
-type client chan<- string // an outgoing message channel
+// A Server is a chat server.  Its exported fields must not be changed
+// once it is serving.
+type Server struct {
+	Queue      int           // length of each client's outgoing queue; 0 means 64
+	Overflow   string        // Drop or Disconnect, when a queue is full; "" means Drop
+	Idle       time.Duration // disconnect clients after this long without input; 0 means never
+	History    int           // number of lines kept for each room
+	Transcript io.Writer     // if not nil, every line said is written to it
+
+	Accounts      *Accounts // registered users; nil means none
+	LoginRequired bool      // clients must log in before chatting
+	Operators     []string  // registered users who may /kick and /ban
+
+	Rate    float64       // lines a second that a client may send; 0 means no limit
+	Burst   int           // lines that a client may send at once; 0 means 1
+	MaxLine int           // bytes in a line; 0 means no limit
+	Strikes int           // broken limits after which a client is muted; 0 means never
+	Mute    time.Duration // how long a client stays muted
+
+	initOnce sync.Once
+	entering chan *client
+	leaving  chan departure
+	messages chan message  // all incoming client messages 客户端数据输入 channel
+	shutdown chan struct{} // closed by Shutdown
+	quit     chan struct{} // closed once every connection is closed
+	ops      map[string]bool
 
-var (
-	entering = make(chan client)
-	leaving  = make(chan client)
-	messages = make(chan string) // all incoming client messages 客户端数据输入 channel
+	mu        sync.Mutex // guards the fields below
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	waiting   map[net.Conn]bool // connections of clients not yet logged in
+	wg        sync.WaitGroup    // counts the running calls of handleConn
+}
+
+// Overflow policies, for when the queue of a client is full.
+const (
+	Drop       = "drop"       // discard the message
+	Disconnect = "disconnect" // disconnect the client
 )
 
-func broadcaster() {
+// ErrServerClosed is returned by Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("chat: Server closed")
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.Queue == 0 {
+			s.Queue = 64
+		}
+		if s.Overflow == "" {
+			s.Overflow = Drop
+		}
+		s.entering = make(chan *client)
+		s.leaving = make(chan departure)
+		s.messages = make(chan message)
+		s.shutdown = make(chan struct{})
+		s.quit = make(chan struct{})
+		s.listeners = make(map[net.Listener]bool)
+		s.conns = make(map[net.Conn]bool)
+		s.waiting = make(map[net.Conn]bool)
+		s.ops = make(map[string]bool)
+		for _, op := range s.Operators {
+			s.ops[op] = true
+		}
+		go s.broadcaster()
+	})
+}
+
+// Serve accepts connections on ln and serves them, until Shutdown is
+// called.  It always returns a non-nil error: after Shutdown,
+// ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.init()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = true
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.listeners, ln)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		/*
+			新连接接入
+		*/
+		go s.handleConn(conn)
+	}
+}
+
+// Shutdown shuts the server down gracefully.  It closes the listeners,
+// tells every client that the server is shutting down, and closes each
+// connection once the queue of its client has been written out.
+// Clients that have not logged in yet are interrupted in their reads.
+// If ctx is done first, Shutdown closes the remaining connections and
+// returns the error of ctx.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.shutdown)
+		go func() {
+			s.wg.Wait()
+			close(s.quit)
+		}()
+	}
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.waiting {
+		conn.SetReadDeadline(time.Now())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		for conn := range s.conns {
+			conn.SetWriteDeadline(deadline)
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.quit:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+//!+broadcaster
+
+// lobby is the room that clients enter on arrival.
+const lobby = "#lobby"
+
+// A client is a connected user.  Its fields other than out, conn and
+// overflow are confined to the broadcaster goroutine.
+type client struct {
+	out      chan<- string // an outgoing message queue
+	conn     net.Conn
+	overflow string
+	name     string
+	room     string
+	user     string // the registered user, once logged in
+	gone     bool   // disconnected by the broadcaster
+
+	bucket  bucket    // for the rate limit
+	strikes int       // limits broken in a row
+	muted   time.Time // when the client may speak again
+}
+
+// A message is a line of input from a client.
+type message struct {
+	from  *client
+	text  string
+	login string // for /login, the user that from has logged in as, or "" on failure
+}
+
+// A departure is the leaving of a client.
+type departure struct {
+	cli    *client
+	notice string // if not empty, the last line sent to the client
+}
+
+func (s *Server) broadcaster() {
 	/*
 		他的内部变量clients会记录当前建立连接的客户端集合。
 		其记录的内容是每一个客户端的消息发出channel的“资格”信息。
+
+		clients 按昵称索引，rooms 记录每个房间里的客户端，
+		消息只会广播给发送者所在房间的客户端。
+	*/
+	clients := make(map[string]*client)        // all connected clients, by name
+	rooms := make(map[string]map[*client]bool) // clients in each room
+	histories := make(map[string]*history)     // lines said in each room
+	banned := make(map[string]bool)            // hosts refused by /ban
+	shutdown := s.shutdown                     // nil once the server is shutting down
+
+	/*
+		send 不会阻塞：客户端的队列满了的时候，
+		按照它的 overflow 策略丢弃消息或者断开它的连接，
+		这样一个不读数据的客户端不会卡住 broadcaster 和其它客户端。
 	*/
-	clients := make(map[client]bool) // all connected clients
+	var leave func(cli *client)
+	send := func(cli *client, msg string) {
+		select {
+		case cli.out <- msg:
+		default:
+			if cli.overflow == Disconnect && !cli.gone {
+				cli.gone = true
+				delete(clients, cli.name)
+				leave(cli)
+				close(cli.out)
+				cli.conn.Close() // handleConn will see an error
+			}
+		}
+	}
+	// expel disconnects cli, after sending it notice.  A client that
+	// has stopped reading is cut off once flushTimeout has passed.
+	expel := func(cli *client, notice string) {
+		send(cli, notice)
+		if !cli.gone {
+			cli.gone = true
+			delete(clients, cli.name)
+			leave(cli)
+			close(cli.out)
+			cli.conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+		}
+	}
+	// broadcast sends msg to every client in room.
+	broadcast := func(room, msg string) {
+		for cli := range rooms[room] {
+			send(cli, msg)
+		}
+	}
+	enter := func(cli *client, room string) {
+		if rooms[room] == nil {
+			rooms[room] = make(map[*client]bool)
+		}
+		rooms[room][cli] = true
+		cli.room = room
+		if h := histories[room]; h != nil {
+			for _, line := range h.last(s.History) {
+				send(cli, line.String())
+			}
+		}
+		broadcast(room, cli.name+" has joined "+room)
+	}
+	leave = func(cli *client) {
+		delete(rooms[cli.room], cli)
+		if len(rooms[cli.room]) == 0 {
+			delete(rooms, cli.room)
+		}
+		broadcast(cli.room, cli.name+" has left "+cli.room)
+	}
+
 	for {
 		select {
-		case msg := <-messages:
-			// Broadcast incoming message to all
-			// clients' outgoing message channels.
+		case msg := <-s.messages:
 			/*
 				broadcaster也会监听全局的消息channel，
 				所有的客户端都会向这个channel中发送消息。
 
-				当broadcaster接收到什么消息时，
-				就会将其广播至所有连接到服务端的客户端。
+				普通消息会广播给同一房间的客户端，
+				以 / 开头的是命令，在下面的 switch 中处理。
 			*/
-			for cli := range clients {
-				cli <- msg
+			cli := msg.from
+			if cli.gone {
+				continue
+			}
+			/*
+				限流：超过限制的消息会被拒绝，
+				连续 Strikes 次超过限制的客户端会被禁言一段时间。
+			*/
+			now := time.Now()
+			if now.Before(cli.muted) {
+				send(cli, fmt.Sprintf("error: you are muted for %s", cli.muted.Sub(now).Round(time.Second)))
+				continue
+			}
+			if broken := s.check(cli, msg.text, now); broken != "" {
+				send(cli, "error: "+broken)
+				cli.strikes++
+				if s.Strikes > 0 && cli.strikes >= s.Strikes && s.Mute > 0 {
+					cli.strikes = 0
+					cli.muted = now.Add(s.Mute)
+					broadcast(cli.room, fmt.Sprintf("%s is muted for %s for flooding", cli.name, s.Mute))
+				}
+				continue
+			}
+			cli.strikes = 0
+			if !strings.HasPrefix(msg.text, "/") {
+				line := said{time.Now(), cli.name, msg.text}
+				h := histories[cli.room]
+				if h == nil {
+					h = newHistory(s.History)
+					histories[cli.room] = h
+				}
+				h.add(line)
+				if s.Transcript != nil {
+					_, err := fmt.Fprintf(s.Transcript, "%s %s %s: %s\n",
+						line.time.Format(time.RFC3339), cli.room, line.from, line.text)
+					if err != nil {
+						log.Printf("transcript: %v", err)
+					}
+				}
+				broadcast(cli.room, cli.name+": "+msg.text)
+				continue
+			}
+			cmd, arg := splitCommand(msg.text)
+			switch cmd {
+			case "/nick":
+				if err := checkName(arg); err != nil {
+					send(cli, "error: "+err.Error())
+				} else if clients[arg] != nil {
+					send(cli, "error: "+arg+" is already taken")
+				} else if s.Accounts.Has(arg) && cli.user != arg {
+					send(cli, "error: "+arg+" is reserved")
+				} else {
+					delete(clients, cli.name)
+					clients[arg] = cli
+					broadcast(cli.room, cli.name+" is now known as "+arg)
+					cli.name = arg
+				}
+
+			case "/login":
+				// handleConn has checked the password.
+				if msg.login == "" {
+					send(cli, "error: login incorrect")
+					break
+				}
+				if other := clients[msg.login]; other != nil && other != cli {
+					send(cli, "error: "+msg.login+" is already logged in")
+					break
+				}
+				cli.user = msg.login
+				if cli.name != msg.login {
+					delete(clients, cli.name)
+					clients[msg.login] = cli
+					broadcast(cli.room, cli.name+" is now known as "+msg.login)
+					cli.name = msg.login
+				}
+				send(cli, "logged in as "+cli.user)
+
+			case "/join":
+				if !strings.HasPrefix(arg, "#") || checkName(arg[1:]) != nil {
+					send(cli, "error: usage: /join #room")
+				} else if arg != cli.room {
+					leave(cli)
+					enter(cli, arg)
+				}
+
+			case "/part":
+				if cli.room == lobby {
+					send(cli, "error: you cannot leave "+lobby)
+				} else {
+					leave(cli)
+					enter(cli, lobby)
+				}
+
+			case "/who":
+				var names []string
+				for c := range rooms[cli.room] {
+					names = append(names, c.name)
+				}
+				sort.Strings(names)
+				send(cli, cli.room+": "+strings.Join(names, " "))
+
+			case "/history":
+				n := s.History
+				if arg != "" {
+					var err error
+					if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+						send(cli, "error: usage: /history [n]")
+						break
+					}
+				}
+				if h := histories[cli.room]; h != nil {
+					for _, line := range h.last(n) {
+						send(cli, line.String())
+					}
+				}
+
+			case "/msg":
+				name, text := splitCommand(arg)
+				if to := clients[name]; to == nil {
+					send(cli, "error: no such user: "+name)
+				} else if text == "" {
+					send(cli, "error: usage: /msg name text")
+				} else {
+					send(to, "*"+cli.name+"*: "+text)
+				}
+
+			case "/kick", "/ban":
+				who, why := splitCommand(arg)
+				if !s.ops[cli.user] {
+					send(cli, "error: "+cmd+" is for operators")
+					break
+				}
+				if who == "" {
+					send(cli, "error: usage: "+cmd+" who [why]")
+					break
+				}
+				// A ban applies to the host of the client, or the address.
+				var victims []*client
+				what := "kicked"
+				if cmd == "/ban" {
+					what = "banned"
+					if c := clients[who]; c != nil {
+						who = host(c.conn)
+					} else if h, _, err := net.SplitHostPort(who); err == nil {
+						who = h
+					}
+					banned[who] = true
+					send(cli, "banned "+who)
+				}
+				for _, c := range clients {
+					if c == cli {
+						continue
+					}
+					if c.name == who || c.conn.RemoteAddr().String() == who || host(c.conn) == who {
+						victims = append(victims, c)
+					}
+				}
+				if len(victims) == 0 && cmd == "/kick" {
+					send(cli, "error: no such user or address: "+who)
+				}
+				if why != "" {
+					why = " (" + why + ")"
+				}
+				for _, c := range victims {
+					broadcast(c.room, c.name+" has been "+what+" by "+cli.name+why)
+					expel(c, "you have been "+what+" by "+cli.name+why)
+				}
+
+			default:
+				send(cli, "error: unknown command "+cmd)
 			}
 
 			/*
@@ -50,50 +500,220 @@ func broadcaster() {
 				当其接收到其中的一个事件时，会更新clients集合，
 				当该事件是离开行为时，它会关闭客户端的消息发送channel。
 			*/
-		case cli := <-entering:
-			clients[cli] = true
+		case cli := <-s.entering:
+			if shutdown == nil {
+				cli.gone = true // too late
+				close(cli.out)
+				continue
+			}
+			if clients[cli.name] != nil {
+				send(cli, "error: "+cli.name+" is already logged in")
+				cli.gone = true
+				close(cli.out)
+				continue
+			}
+			if banned[host(cli.conn)] {
+				send(cli, "error: you are banned")
+				cli.gone = true
+				close(cli.out)
+				continue
+			}
+			clients[cli.name] = cli
+			enter(cli, lobby)
+
+		case d := <-s.leaving:
+			cli := d.cli
+			if cli.gone {
+				continue // already disconnected
+			}
+			if d.notice != "" {
+				send(cli, d.notice)
+				if cli.gone {
+					continue
+				}
+			}
+			delete(clients, cli.name)
+			leave(cli)
+			close(cli.out)
+
+			/*
+				关闭服务器时，通知所有客户端，然后关闭它们的消息发送channel，
+				clientWriter 把队列中剩下的消息写完后会关闭连接。
+			*/
+		case <-shutdown:
+			shutdown = nil
+			for _, cli := range clients {
+				send(cli, "server shutting down")
+				if !cli.gone {
+					cli.gone = true
+					close(cli.out)
+				}
+			}
+			clients = make(map[string]*client)
+			rooms = make(map[string]map[*client]bool)
 
-		case cli := <-leaving:
-			delete(clients, cli)
-			close(cli)
+		case <-s.quit:
+			return
 		}
 	}
 }
 
+// splitCommand splits line at its first space.
+func splitCommand(line string) (cmd, arg string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+// checkName reports whether name may be used as a nickname.
+func checkName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if strings.ContainsAny(name, " \t#/*:") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
 //!-broadcaster
 
+// flushTimeout bounds the time spent writing to a leaving client.
+// It is a variable for the tests.
+var flushTimeout = 5 * time.Second
+
+// maxLoginFailures is the number of failed logins after which a
+// client is disconnected.
+const maxLoginFailures = 3
+
+// checkLogin checks the "name password" argument of /login, and
+// returns the name if the password is right.  It is slow.
+func (s *Server) checkLogin(arg string) string {
+	name, password := splitCommand(arg)
+	if !s.Accounts.Check(name, password) {
+		return ""
+	}
+	return name
+}
+
 //!+handleConn
-func handleConn(conn net.Conn) {
-	ch := make(chan string) // outgoing client messages
+func (s *Server) handleConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	ch := make(chan string, s.Queue) // outgoing client messages
 	/*
 		handleConn为每一个客户端创建了一个clientWriter的goroutine，
 		用来接收向客户端发送消息的channel中的广播消息，
 		并将它们写入到客户端的网络连接。
 
 		客户端的读取循环会在broadcaster接收到leaving通知并关闭了channel后终止。
+		channel 关闭、消息写完以后，连接也会被关闭。
 	*/
-	go clientWriter(conn, ch)
+	done := make(chan struct{})
+	go func() {
+		clientWriter(conn, ch)
+		conn.Close()
+		close(done)
+	}()
 	/*
-	   远程连接信息
+	   远程连接信息，作为初始昵称
 	*/
-	who := conn.RemoteAddr().String()
-	ch <- "You are " + who
-	// 广播信息
-	messages <- who + " has arrived"
-	// 通过entering channel来通知客户端的到来。
-	// 更新 clients map 数组
-	entering <- ch
-	// 广播消息
+	cli := &client{
+		out:      ch,
+		conn:     conn,
+		overflow: s.Overflow,
+		name:     conn.RemoteAddr().String(),
+	}
+	ch <- "You are " + cli.name
 	input := bufio.NewScanner(conn)
-	//  阻塞循环等待输入
-	for input.Scan() {
-		messages <- who + ": " + input.Text()
+	waiting := false // the client is at the login prompt
+	//  阻塞等待输入，超过 s.Idle 没有输入就断开
+	scan := func() bool {
+		if s.Idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.Idle))
+		}
+		if waiting && s.closing() {
+			return false // the deadline set by Shutdown may just have been replaced
+		}
+		return input.Scan() && strings.TrimSpace(input.Text()) != "/quit"
+	}
+	/*
+		密码的检查（bcrypt）很慢，所以在每个客户端自己的 goroutine 中进行，
+		而不是在 broadcaster 中。
+	*/
+	failures := 0
+	if s.LoginRequired {
+		// The broadcaster does not know of the client until it enters,
+		// so Shutdown must reach it here.
+		s.setWaiting(conn, true)
+		waiting = true
+		ch <- "Log in with /login name password"
+		for cli.user == "" {
+			if failures == maxLoginFailures || !scan() {
+				s.setWaiting(conn, false)
+				if s.closing() {
+					ch <- "server shutting down"
+				}
+				close(ch) // the client never entered
+				conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+				<-done
+				return
+			}
+			if cmd, arg := splitCommand(input.Text()); cmd != "/login" {
+				ch <- "error: log in first"
+			} else if user := s.checkLogin(arg); user == "" {
+				failures++
+				ch <- "error: login incorrect"
+			} else {
+				cli.name, cli.user = user, user
+			}
+		}
+		s.setWaiting(conn, false)
+		waiting = false
+	}
+	// 通过entering channel来通知客户端的到来。
+	// broadcaster 会更新 clients 并在 #lobby 中广播
+	s.entering <- cli
+	for failures < maxLoginFailures && scan() {
+		cmd, arg := splitCommand(input.Text())
+		if cmd != "/login" {
+			s.messages <- message{from: cli, text: input.Text()}
+			continue
+		}
+		user := s.checkLogin(arg)
+		if user == "" {
+			failures++
+		}
+		s.messages <- message{from: cli, text: cmd, login: user}
 	}
-	// NOTE: ignoring potential errors from input.Err()
 	// 连接断开
-	leaving <- ch
-	messages <- who + " has left"
-	conn.Close()
+	var notice string
+	if failures == maxLoginFailures {
+		notice = "too many failed logins"
+	} else if err, ok := input.Err().(net.Error); ok && err.Timeout() {
+		notice = fmt.Sprintf("disconnected after %s of inactivity", s.Idle)
+	}
+	// NOTE: ignoring other errors from input.Err()
+	s.leaving <- departure{cli, notice}
+	// Give clientWriter a moment to send the last messages.
+	conn.SetWriteDeadline(time.Now().Add(flushTimeout))
+	<-done
 }
 
 func clientWriter(conn net.Conn, ch <-chan string) {
@@ -104,25 +724,23 @@ func clientWriter(conn net.Conn, ch <-chan string) {
 
 //!-handleConn
 
-//!+main
-func main() {
-	listener, err := net.Listen("tcp", "localhost:8000")
-	if err != nil {
-		log.Fatal(err)
+// setWaiting records whether conn belongs to a client at the login prompt.
+func (s *Server) setWaiting(conn net.Conn, waiting bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if waiting {
+		s.waiting[conn] = true
+	} else {
+		delete(s.waiting, conn)
 	}
+}
 
-	go broadcaster()
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		/*
-			新连接接入
-		*/
-		go handleConn(conn)
+// closing reports whether Shutdown has been called.
+func (s *Server) closing() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
 	}
 }
-
-//!-main
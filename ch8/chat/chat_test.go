@@ -0,0 +1,383 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A lockedBuffer is a bytes.Buffer for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// start serves s on a loopback listener, until the end of the test,
+// and returns its address.
+func start(t *testing.T, s *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, s, listener)
+	return listener.Addr().String()
+}
+
+// serve serves s on listener until the end of the test.
+func serve(t *testing.T, s *Server, listener net.Listener) {
+	go s.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+}
+
+type testClient struct {
+	t     *testing.T
+	name  string
+	conn  net.Conn
+	input *bufio.Scanner
+}
+
+// dial connects a client to the server at addr and names it.
+func dial(t *testing.T, addr, name string) *testClient {
+	t.Helper()
+	c := connect(t, addr)
+	c.name = name
+	c.send("/nick " + name)
+	c.expect("is now known as " + name)
+	return c
+}
+
+// connect connects a client to the server at addr, without naming it.
+func connect(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t, "", conn, bufio.NewScanner(conn)}
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads lines until one ends with suffix, and returns the
+// lines before it.
+func (c *testClient) expect(suffix string) []string {
+	c.t.Helper()
+	var skipped []string
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for c.input.Scan() {
+		if strings.HasSuffix(c.input.Text(), suffix) {
+			return skipped
+		}
+		skipped = append(skipped, c.input.Text())
+	}
+	c.t.Fatalf("no line ending %q; got %q (%v)", suffix, skipped, c.input.Err())
+	return nil
+}
+
+// expectNot is like expect, but fails if a line skipped contains bad.
+func (c *testClient) expectNot(suffix, bad string) {
+	c.t.Helper()
+	for _, line := range c.expect(suffix) {
+		if strings.Contains(line, bad) {
+			c.t.Errorf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestRooms(t *testing.T) {
+	addr := start(t, &Server{})
+	alice := dial(t, addr, "alice")
+	bob := dial(t, addr, "bob")
+	carol := dial(t, addr, "carol")
+
+	alice.send("/join #go")
+	alice.expect("alice has joined #go")
+	bob.send("/join #go")
+	alice.expect("bob has joined #go")
+	bob.expect("bob has joined #go")
+
+	alice.send("hello, gophers")
+	bob.expect("alice: hello, gophers")
+
+	// carol is still in the lobby, so she must not see alice's message.
+	carol.send("marker")
+	carol.expectNot("carol: marker", "hello, gophers")
+
+	bob.send("/who")
+	bob.expect("#go: alice bob")
+
+	bob.send("/part")
+	alice.expect("bob has left #go")
+	bob.expect("bob has joined #lobby")
+	bob.send("/part")
+	bob.expect("error: you cannot leave #lobby")
+}
+
+func TestPrivateMessage(t *testing.T) {
+	addr := start(t, &Server{})
+	dave := dial(t, addr, "dave")
+	erin := dial(t, addr, "erin")
+	frank := dial(t, addr, "frank")
+
+	dave.send("/msg erin psst")
+	erin.expect("*dave*: psst")
+	dave.send("/msg nobody psst")
+	dave.expect("error: no such user: nobody")
+
+	frank.send("marker")
+	frank.expectNot("frank: marker", "psst")
+}
+
+func TestCommandErrors(t *testing.T) {
+	addr := start(t, &Server{})
+	gina := dial(t, addr, "gina")
+	hank := dial(t, addr, "hank")
+
+	gina.send("/frobnicate")
+	gina.expect("error: unknown command /frobnicate")
+	gina.send("/nick hank")
+	gina.expect("error: hank is already taken")
+	gina.send("/join lobby")
+	gina.expect("error: usage: /join #room")
+
+	// Errors go to the sender only.
+	hank.send("marker")
+	hank.expectNot("hank: marker", "error")
+}
+
+func TestQuit(t *testing.T) {
+	addr := start(t, &Server{})
+	ivan := dial(t, addr, "ivan")
+	judy := dial(t, addr, "judy")
+
+	ivan.send("/quit")
+	judy.expect("ivan has left #lobby")
+	ivan.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for ivan.input.Scan() {
+	}
+	if err := ivan.input.Err(); err != nil {
+		t.Errorf("connection not closed after /quit: %v", err)
+	}
+}
+
+// stall connects a client that never reads, to room.
+func stall(t *testing.T, addr, room string) net.Conn {
+	t.Helper()
+	c := dial(t, addr, "stalled"+room[1:])
+	c.send("/join " + room)
+	c.expect("has joined " + room)
+	return c.conn
+}
+
+// chatter sends n long messages from one client to another, one at a
+// time, and fails unless each of them arrives within a few seconds.
+func chatter(t *testing.T, from, to *testClient, n int) {
+	t.Helper()
+	long := strings.Repeat("x", 1000)
+	for i := 0; i < n; i++ {
+		from.send(fmt.Sprintf("%d %s", i, long))
+		to.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		want := fmt.Sprintf("%s: %d %s", from.name, i, long)
+		for {
+			if !to.input.Scan() {
+				t.Fatalf("message %d of %d not received: %v", i, n, to.input.Err())
+			}
+			if to.input.Text() == want {
+				break
+			}
+		}
+	}
+}
+
+func TestStalledReaderDropped(t *testing.T) {
+	addr := start(t, &Server{Queue: 8, Overflow: Drop})
+	stall(t, addr, "#slow")
+	kate := dial(t, addr, "kate")
+	leo := dial(t, addr, "leo")
+	kate.send("/join #slow")
+	leo.send("/join #slow")
+	leo.expect("leo has joined #slow")
+
+	// Far more than the stalled client's queue and socket buffers
+	// can hold.
+	chatter(t, kate, leo, 10000)
+}
+
+func TestStalledReaderDisconnected(t *testing.T) {
+	addr := start(t, &Server{Queue: 8, Overflow: Disconnect})
+	stall(t, addr, "#slower")
+	mia := dial(t, addr, "mia")
+	ned := dial(t, addr, "ned")
+	mia.send("/join #slower")
+	ned.send("/join #slower")
+	ned.expect("ned has joined #slower")
+
+	chatter(t, mia, ned, 10000)
+	ned.send("/who")
+	ned.expect("#slower: mia ned")
+}
+
+func TestIdle(t *testing.T) {
+	addr := start(t, &Server{Idle: 200 * time.Millisecond})
+	olga := dial(t, addr, "olga")
+	olga.expect("disconnected after 200ms of inactivity")
+	olga.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for olga.input.Scan() {
+	}
+	if err := olga.input.Err(); err != nil {
+		t.Errorf("connection not closed when idle: %v", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	transcript := new(lockedBuffer)
+	addr := start(t, &Server{History: 5, Transcript: transcript})
+	pat := dial(t, addr, "pat")
+	pat.send("/join #hist")
+	for i := 0; i < 7; i++ {
+		pat.send(fmt.Sprintf("line %d", i))
+	}
+	pat.expect("pat: line 6")
+
+	// quinn sees the last 5 lines, oldest first, on entering.
+	quinn := dial(t, addr, "quinn")
+	quinn.send("/join #hist")
+	var replayed []string
+	for _, line := range quinn.expect("quinn has joined #hist") {
+		if strings.HasPrefix(line, "[") {
+			replayed = append(replayed, line[strings.Index(line, "]")+2:])
+		}
+	}
+	want := "pat: line 2,pat: line 3,pat: line 4,pat: line 5,pat: line 6"
+	if got := strings.Join(replayed, ","); got != want {
+		t.Errorf("replayed %q, want %q", got, want)
+	}
+
+	quinn.send("/history 2")
+	if lines := quinn.expect("pat: line 6"); len(lines) != 1 || !strings.HasSuffix(lines[0], "pat: line 5") {
+		t.Errorf("/history 2 began with %q, want line 5", lines)
+	}
+	quinn.send("/history two")
+	quinn.expect("error: usage: /history [n]")
+
+	if !strings.Contains(transcript.String(), " #hist pat: line 6\n") {
+		t.Errorf("transcript lacks line 6:\n%s", transcript.String())
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("chatting", func(t *testing.T) {
+		s := &Server{}
+		addr := listen(t, s)
+		tom := dial(t, addr, "tom")
+		uma := dial(t, addr, "uma")
+		shutdown(t, s, addr, tom, uma)
+	})
+	t.Run("at login", func(t *testing.T) {
+		s := &Server{
+			Accounts:      accounts(t, map[string]string{"wendy": "s3cret"}),
+			LoginRequired: true,
+			Idle:          time.Minute,
+		}
+		addr := listen(t, s)
+		wendy := connect(t, addr)
+		wendy.send("/login wendy s3cret")
+		wendy.expect("wendy has joined #lobby")
+		zed := connect(t, addr) // never logs in
+		zed.expect("Log in with /login name password")
+		shutdown(t, s, addr, wendy, zed)
+	})
+}
+
+// listen serves s on a loopback listener, which shutdown expects to be
+// closed, and returns its address.
+func listen(t *testing.T, s *Server) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listener) }()
+	t.Cleanup(func() {
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("Serve returned %v, want ErrServerClosed", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+// shutdown shuts s down, and checks that every client is told so and
+// disconnected, and that addr no longer accepts connections.
+func shutdown(t *testing.T, s *Server, addr string, clients ...*testClient) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	for _, c := range clients {
+		c.expect("server shutting down")
+		if c.input.Scan() || c.input.Err() != nil {
+			t.Errorf("%s: connection not closed after shutdown (%v)", c.name, c.input.Err())
+		}
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("server still accepting after shutdown")
+	}
+}
+
+func TestShutdownStalled(t *testing.T) {
+	s := &Server{Queue: 1000}
+	addr := start(t, s)
+	stall(t, addr, "#stuck")
+	vic := dial(t, addr, "vic")
+	vic.send("/join #stuck")
+	// More than the stalled client's socket buffers can hold.
+	long := strings.Repeat("x", 10000)
+	for i := 0; i < 900; i++ {
+		vic.send(long)
+	}
+	vic.send("/who")
+	vic.expect("#stuck: stalledstuck vic")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	s.Shutdown(ctx) // may or may not be in time
+	if d := time.Since(begin); d > 2*time.Second {
+		t.Errorf("Shutdown took %s despite its deadline", d)
+	}
+}
@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+)
+
+// A said is a line said by a client in a room.
+type said struct {
+	time time.Time
+	from string
+	text string
+}
+
+func (s said) String() string {
+	return fmt.Sprintf("[%s] %s: %s", s.time.Format("15:04:05"), s.from, s.text)
+}
+
+// A history is a ring buffer of the last lines said in a room.
+type history struct {
+	lines []said
+	next  int // index of the oldest line once lines is full
+}
+
+func newHistory(n int) *history {
+	return &history{lines: make([]said, 0, n)}
+}
+
+// add records s, forgetting the oldest line if the history is full.
+func (h *history) add(s said) {
+	if cap(h.lines) == 0 {
+		return
+	}
+	if len(h.lines) < cap(h.lines) {
+		h.lines = append(h.lines, s)
+		return
+	}
+	h.lines[h.next] = s
+	h.next = (h.next + 1) % len(h.lines)
+}
+
+// last returns the last n lines, oldest first.
+func (h *history) last(n int) []said {
+	if n > len(h.lines) {
+		n = len(h.lines)
+	}
+	var lines []said
+	for i := len(h.lines) - n; i < len(h.lines); i++ {
+		lines = append(lines, h.lines[(h.next+i)%len(h.lines)])
+	}
+	return lines
+}
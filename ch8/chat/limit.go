@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// A bucket is a token bucket.  It holds up to burst tokens and gains
+// rate tokens a second; each line that a client sends takes one.
+type bucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+// take reports whether a token was available at time now, and takes it.
+func (b *bucket) take(now time.Time, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst) // a new bucket is full
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// check returns why the line text, sent by cli at time now, breaks the
+// limits of s, or "" if it does not.
+func (s *Server) check(cli *client, text string, now time.Time) string {
+	if s.MaxLine > 0 && len(text) > s.MaxLine {
+		return fmt.Sprintf("line too long (the limit is %d bytes)", s.MaxLine)
+	}
+	if s.Rate > 0 && !cli.bucket.take(now, s.Rate, s.Burst) {
+		return "slow down"
+	}
+	return ""
+}
+
+// host returns the host part of the remote address of conn.
+func host(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
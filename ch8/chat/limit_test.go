@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	t0 := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, test := range []struct {
+		at   time.Duration // since t0
+		want bool
+	}{
+		{0, true}, // a new bucket holds a burst of 3
+		{0, true},
+		{0, true},
+		{0, false},
+		{400 * time.Millisecond, false}, // 0.8 tokens
+		{500 * time.Millisecond, true},  // 1 token
+		{500 * time.Millisecond, false},
+		{time.Hour, true}, // no more than a burst
+		{time.Hour, true},
+		{time.Hour, true},
+		{time.Hour, false},
+	} {
+		if got := b.take(t0.Add(test.at), 2, 3); got != test.want {
+			t.Errorf("%d: take at %s = %t, want %t", i, test.at, got, test.want)
+		}
+	}
+}
+
+func TestFlood(t *testing.T) {
+	addr := start(t, &Server{Rate: 0.1, Burst: 4, MaxLine: 20, Strikes: 2, Mute: time.Hour})
+	amy := dial(t, addr, "amy")
+	ben := dial(t, addr, "ben")
+	amy.send("/join #flood")
+	ben.send("/join #flood")
+	ben.expect("ben has joined #flood")
+
+	// /nick and /join have taken two of the four tokens.
+	ben.send("one")
+	ben.expect("ben: one")
+	ben.send(strings.Repeat("x", 21))
+	ben.expect("error: line too long (the limit is 20 bytes)")
+	ben.send("two")
+	ben.expect("ben: two") // a success resets the strikes
+	ben.send("three")
+	ben.expect("error: slow down")
+	ben.send("four")
+	ben.expect("error: slow down")
+	amy.expect("ben is muted for 1h0m0s for flooding")
+	ben.send("five")
+	ben.expect("error: you are muted for 1h0m0s")
+
+	amy.send("hello")
+	amy.expectNot("amy: hello", "ben: three")
+}
+
+func TestKickBan(t *testing.T) {
+	s := &Server{
+		Accounts:  accounts(t, map[string]string{"oscar": "op"}),
+		Operators: []string{"oscar"},
+	}
+	addr := start(t, s)
+	oscar := dial(t, addr, "oscar0")
+	oscar.send("/login oscar op")
+	oscar.expect("logged in as oscar")
+	cal := dial(t, addr, "cal")
+	dee := dial(t, addr, "dee")
+
+	cal.send("/kick dee")
+	cal.expect("error: /kick is for operators")
+
+	oscar.send("/kick dee spamming")
+	dee.expect("you have been kicked by oscar (spamming)")
+	cal.expect("dee has been kicked by oscar (spamming)")
+	dee.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for dee.input.Scan() {
+	}
+	if err := dee.input.Err(); err != nil {
+		t.Errorf("connection not closed after /kick: %v", err)
+	}
+	oscar.send("/kick dee")
+	oscar.expect("error: no such user or address: dee")
+
+	// Every test client is at 127.0.0.1, so the ban applies to all
+	// but the operator.
+	oscar.send("/ban cal")
+	oscar.expect("banned 127.0.0.1")
+	cal.expect("you have been banned by oscar")
+	oscar.expect("cal has left #lobby")
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	again := &testClient{t, "", conn, bufio.NewScanner(conn)}
+	again.expect("error: you are banned")
+}
+
+func TestKickStalled(t *testing.T) {
+	defer func(d time.Duration) { flushTimeout = d }(flushTimeout)
+	flushTimeout = 100 * time.Millisecond
+	s := &Server{
+		Queue:     1000,
+		Accounts:  accounts(t, map[string]string{"oscar": "op"}),
+		Operators: []string{"oscar"},
+	}
+	addr := start(t, s)
+	oscar := dial(t, addr, "oscar0")
+	oscar.send("/login oscar op")
+	oscar.expect("logged in as oscar")
+	stall(t, addr, "#stuck")
+	vic := dial(t, addr, "vic")
+	vic.send("/join #stuck")
+	// More than the stalled client's socket buffers can hold.
+	long := strings.Repeat("x", 10000)
+	for i := 0; i < 900; i++ {
+		vic.send(long)
+	}
+	vic.send("/who")
+	vic.expect("#stuck: stalledstuck vic")
+
+	oscar.send("/kick stalledstuck")
+	vic.expect("stalledstuck has been kicked by oscar")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections after /kick of a stalled client, want 2", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright © 2016 Alan A. A. Donovan & Brian W. Kernighan.
+// License: https://creativecommons.org/licenses/by-nc-sa/4.0/
+
+//go:build ignore
+// +build ignore
+
+// Chat is a server that lets clients chat with each other.
+// See package chat for the commands that clients may use.
+//
+// The "+build ignore" tag excludes this file from the chat package,
+// but it can be compiled as a command and run like this:
+//
+//	$ go run main.go -http localhost:8080
+//
+// With -cert and -key, clients connect with TLS, to both listeners.
+// With -accounts, registered users log in with /login, and with -login
+// they must.  To add a user to an accounts file, run
+//
+//	$ go run main.go -passwd name >>accounts
+//	password
+//	^D
+//
+// An interrupt shuts the server down gracefully: it stops accepting
+// connections, tells the clients, and gives them up to -grace to
+// receive what is queued for them.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopl.io/ch8/chat"
+)
+
+var (
+	addr     = flag.String("addr", "localhost:8000", "listen for TCP clients at `address`")
+	httpAddr = flag.String("http", "", "serve the web client at `address`")
+	grace    = flag.Duration("grace", 10*time.Second, "time allowed for a graceful shutdown")
+)
+
+// Limits on each client.
+var (
+	queue    = flag.Int("queue", 64, "length of each client's outgoing queue")
+	overflow = flag.String("overflow", chat.Drop, "when a client's queue is full, `drop` the message or disconnect the client")
+	idle     = flag.Duration("idle", 30*time.Minute, "disconnect clients after this long without input (0 means never)")
+)
+
+var (
+	certFile     = flag.String("cert", "", "TLS certificate `file`")
+	keyFile      = flag.String("key", "", "TLS key `file`")
+	accountsFile = flag.String("accounts", "", "registered users and their password hashes")
+	login        = flag.Bool("login", false, "require clients to log in")
+	operators    = flag.String("ops", "", "comma-separated `names` of the registered users who may /kick and /ban")
+	passwd       = flag.String("passwd", "", "print the accounts file line for user `name`, with the password read from the standard input, and exit")
+)
+
+// Flood control.
+var (
+	rate    = flag.Float64("rate", 2, "lines a second that each client may send (0 means no limit)")
+	burst   = flag.Int("burst", 5, "lines that each client may send at once")
+	maxLine = flag.Int("maxline", 1024, "longest line that a client may send, in bytes (0 means no limit)")
+	strikes = flag.Int("strikes", 3, "limits broken in a row after which a client is muted (0 means never)")
+	mute    = flag.Duration("mute", time.Minute, "how long a client stays muted")
+)
+
+var (
+	history        = flag.Int("history", 50, "number of lines kept for each room")
+	transcriptFile = flag.String("transcript", "", "append the lines said to `file`")
+)
+
+//!+main
+func main() {
+	flag.Parse()
+	if *queue < 1 || *history < 0 || (*overflow != chat.Drop && *overflow != chat.Disconnect) ||
+		(*certFile == "") != (*keyFile == "") || ((*login || *operators != "") && *accountsFile == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *passwd != "" {
+		input := bufio.NewScanner(os.Stdin)
+		input.Scan()
+		line, err := chat.HashPassword(*passwd, input.Text())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(line)
+		return
+	}
+
+	server := &chat.Server{
+		Queue:    *queue,
+		Overflow: *overflow,
+		Idle:     *idle,
+		History:  *history,
+
+		LoginRequired: *login,
+
+		Rate:    *rate,
+		Burst:   *burst,
+		MaxLine: *maxLine,
+		Strikes: *strikes,
+		Mute:    *mute,
+	}
+	if *operators != "" {
+		server.Operators = strings.Split(*operators, ",")
+	}
+	if *accountsFile != "" {
+		accounts, err := chat.LoadAccounts(*accountsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Accounts = accounts
+		if *certFile == "" {
+			log.Print("warning: passwords will be sent in clear text; use -cert and -key")
+		}
+	}
+	if *transcriptFile != "" {
+		f, err := os.OpenFile(*transcriptFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		server.Transcript = f
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tlsConfig *tls.Config
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	go func() {
+		if err := server.Serve(listener); err != chat.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	var web *http.Server
+	if *httpAddr != "" {
+		web = &http.Server{Addr: *httpAddr, Handler: server.Handler(), TLSConfig: tlsConfig}
+		go func() {
+			var err error
+			if tlsConfig != nil {
+				err = web.ListenAndServeTLS("", "")
+			} else {
+				err = web.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	log.Print("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	if web != nil {
+		web.Shutdown(ctx) // the WebSocket connections are the chat server's
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print(err)
+	}
+}
+
+//!-main
@@ -0,0 +1,305 @@
+package chat
+
+// This file implements just enough of the WebSocket protocol (RFC 6455)
+// to let browsers chat: the opening handshake, and text messages in
+// both directions.  A WebSocket connection is presented as a net.Conn
+// carrying lines, so that handleConn serves it like a TCP connection.
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxMessage bounds the size of a message from a client.
+const maxMessage = 64 << 10
+
+var (
+	errTooLarge = errors.New("websocket: message too large")
+	errProtocol = errors.New("websocket: protocol error")
+)
+
+// A frame is the unit of the WebSocket protocol.
+type frame struct {
+	fin     bool // last frame of a message
+	op      byte
+	masked  bool // as frames from clients must be
+	payload []byte
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var f frame
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return f, err
+	}
+	f.fin = hdr[0]&0x80 != 0
+	f.op = hdr[0] & 0x0f
+	f.masked = hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessage {
+		return f, errTooLarge
+	}
+	var key [4]byte
+	if f.masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return f, err
+		}
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, err
+	}
+	if f.masked {
+		for i := range f.payload {
+			f.payload[i] ^= key[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeFrame writes a single-frame message.  Only clients mask.
+func writeFrame(w io.Writer, op byte, payload []byte, mask bool) error {
+	buf := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		buf[1] = byte(n)
+	case n <= 0xffff:
+		buf[1] = 126
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(n))
+		buf = append(buf, ext[:]...)
+	default:
+		buf[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		buf = append(buf, ext[:]...)
+	}
+	if !mask {
+		buf = append(buf, payload...)
+	} else {
+		buf[1] |= 0x80
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		for i, b := range payload {
+			buf = append(buf, b^key[i%4])
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// A wsConn is the server end of a WebSocket connection.  Each message
+// read from it is followed by a newline, and each Write sends one
+// message, without its final newline.  Deadlines apply to the
+// underlying connection.
+type wsConn struct {
+	net.Conn
+	r   *bufio.Reader
+	buf []byte // unread part of the current message
+
+	mu sync.Mutex // serializes writes
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.buf = append(msg, '\n')
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// readMessage returns the next data message, answering the control
+// frames that precede it.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		f, err := readFrame(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if !f.masked {
+			return nil, errProtocol
+		}
+		switch f.op {
+		case opPing:
+			if err := c.writeFrame(opPong, f.payload); err != nil {
+				return nil, err
+			}
+		case opPong:
+			// ignore
+		case opClose:
+			c.writeFrame(opClose, nil) // NOTE: ignoring errors
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+			if started != (f.op == opContinuation) {
+				return nil, errProtocol
+			}
+			started = true
+			msg = append(msg, f.payload...)
+			if len(msg) > maxMessage {
+				return nil, errTooLarge
+			}
+			if f.fin {
+				return msg, nil
+			}
+		default:
+			return nil, errProtocol
+		}
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opText, []byte(strings.TrimSuffix(string(p), "\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFrame(c.Conn, op, payload, false)
+}
+
+// acceptKey returns the Sec-WebSocket-Accept header for key.
+func acceptKey(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+"258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains reports whether the comma-separated list in header
+// name of h contains token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsHandler returns a handler that accepts WebSocket connections and
+// serves them like TCP connections.
+func (s *Server) wsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if r.Method != "GET" ||
+			!headerContains(r.Header, "Connection", "upgrade") ||
+			!headerContains(r.Header, "Upgrade", "websocket") ||
+			r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+			http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "cannot upgrade the connection", http.StatusInternalServerError)
+			return
+		}
+		conn, brw, err := hj.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conn.SetDeadline(time.Time{}) // the http.Server's deadlines no longer apply
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+		if err := brw.Flush(); err != nil {
+			conn.Close()
+			return
+		}
+		s.handleConn(&wsConn{Conn: conn, r: brw.Reader})
+	})
+}
+
+// Handler returns the handler of the HTTP front end: a page at / that
+// chats over a WebSocket connection to /ws.  The connections to /ws
+// are shut down with the server.
+func (s *Server) Handler() http.Handler {
+	s.init()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	})
+	mux.Handle("/ws", s.wsHandler())
+	return mux
+}
+
+const page = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Chat</title></head>
+<body>
+<pre id="log"></pre>
+<form id="form"><input id="line" size="80" autofocus autocomplete="off"></form>
+<script>
+var log = document.getElementById("log");
+var line = document.getElementById("line");
+var scheme = location.protocol == "https:" ? "wss://" : "ws://";
+var ws = new WebSocket(scheme + location.host + "/ws");
+ws.onmessage = function(e) {
+	log.textContent += e.data + "\n";
+	window.scrollTo(0, document.body.scrollHeight);
+};
+ws.onclose = function() { log.textContent += "(disconnected)\n"; };
+document.getElementById("form").onsubmit = function(e) {
+	e.preventDefault();
+	ws.send(line.value);
+	line.value = "";
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A wsClient is the client end of a WebSocket connection.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWS performs the opening handshake with the server at url.
+func dialWS(t *testing.T, url string) *wsClient {
+	t.Helper()
+	host := strings.TrimPrefix(url, "http://")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n", host, key)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %s", resp.Status)
+	}
+	if got, want := resp.Header.Get("Sec-WebSocket-Accept"), acceptKey(key); got != want {
+		t.Fatalf("Sec-WebSocket-Accept = %q, want %q", got, want)
+	}
+	return &wsClient{t, conn, r}
+}
+
+func (c *wsClient) send(op byte, text string) {
+	c.t.Helper()
+	if err := writeFrame(c.conn, op, []byte(text), true); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads frames until a text message ends with suffix.
+func (c *wsClient) expect(op byte, suffix string) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		f, err := readFrame(c.r)
+		if err != nil {
+			c.t.Fatalf("no message ending %q: %v", suffix, err)
+		}
+		if f.masked {
+			c.t.Errorf("server sent a masked frame")
+		}
+		if f.op == op && strings.HasSuffix(string(f.payload), suffix) {
+			return
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	s := &Server{}
+	addr := start(t, s)
+	web := httptest.NewServer(s.Handler())
+	defer web.Close()
+
+	resp, err := http.Get(web.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "new WebSocket(") {
+		t.Errorf("GET / returned no web client:\n%s", body)
+	}
+
+	resp, err = http.Get(web.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /ws without handshake: %s, want 400", resp.Status)
+	}
+
+	// A browser and a netcat client share a room.
+	browser := dialWS(t, web.URL)
+	browser.send(opText, "/nick rita")
+	browser.expect(opText, "is now known as rita")
+	browser.send(opText, "/join #web")
+	browser.expect(opText, "rita has joined #web")
+
+	sam := dial(t, addr, "sam")
+	sam.send("/join #web")
+	browser.expect(opText, "sam has joined #web")
+
+	browser.send(opText, "hello from the browser")
+	sam.expect("rita: hello from the browser")
+	sam.send("hello from netcat")
+	browser.expect(opText, "sam: hello from netcat")
+
+	browser.send(opPing, "are you there?")
+	browser.expect(opPong, "are you there?")
+
+	browser.send(opClose, "")
+	browser.expect(opClose, "")
+	sam.expect("rita has left #web")
+}
@@ -8,7 +8,8 @@
 // This version quickly exhausts available file descriptors
 // due to excessive concurrent calls to links.Extract.
 //
-// Also, it never terminates because the worklist is never closed.
+// Also, it never terminates because the worklist is never closed,
+// so unlike crawl3 it cannot write the link graph of its crawl.
 /*
 用bfs(广度优先)算法来抓取整个网站。
 在本节中，我们会让这个爬虫并行化，这样每一个彼此独立的抓取命令可以并行进行IO，最大化利用网络资源。
@@ -38,6 +39,10 @@ func crawl(url string) []string {
 //!+main
 func main() {
 	worklist := make(chan []string)
+	seeds, err := links.CanonicalAll(os.Args[1:]) // as links.Extract returns them
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Start with the command-line arguments.
 	// 第一个要进行处理的链接
@@ -45,7 +50,7 @@ func main() {
 	// 这是为了避免channel两端的main goroutine与crawler goroutine都尝试向对方发送内容，
 	// 却没有一端接收内容时发生死锁。(存在多个链接参数的时候，会出现这个问题）
 	// 当然，这里我们也可以用buffered channel来解决问题，这里不再赘述。
-	go func() { worklist <- os.Args[1:] }()
+	go func() { worklist <- seeds }()
 
 	// Crawl the web concurrently.
 	seen := make(map[string]bool)
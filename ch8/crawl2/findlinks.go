@@ -6,15 +6,26 @@
 // Crawl2 crawls web links starting with the command-line arguments.
 //
 // This version uses a buffered channel as a counting semaphore
-// to limit the number of concurrent calls to links.Extract.
+// to limit the number of concurrent calls to links.Extract,
+// and a crawler.Scheduler to limit those to each host.
+//
+// On an interrupt it stops starting new fetches, waits for those under
+// way, and like a normal finish prints a summary of the crawl.
+//
+// It only lists the pages it visits; crawl3 records the link graph of
+// a crawl, with the status, content type and latency of each page.
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"time"
 
 	"gopl.io/ch5/links"
+	"gopl.io/ch8/crawler"
 )
 
 //!+sema
@@ -32,7 +43,18 @@ import (
 */
 var tokens = make(chan struct{}, 20)
 
-func crawl(url string) []string {
+// hosts limits the requests to each host to 2 at a time.  A crawl
+// waits for its host before it takes a token, so that the crawls of a
+// slow host cannot hold all the tokens.
+var hosts = &crawler.Scheduler{PerHost: 2}
+
+func crawl(ctx context.Context, url string) ([]string, error) {
+	host := crawler.Host(url)
+	if err := hosts.Acquire(ctx, host); err != nil {
+		return nil, err // interrupted
+	}
+	defer hosts.Done(host)
+
 	fmt.Println(url)
 	// 申请一个资源
 	tokens <- struct{}{} // acquire a token
@@ -43,15 +65,34 @@ func crawl(url string) []string {
 	if err != nil {
 		log.Print(err)
 	}
-	return list
+	return list, err
 }
 
 //!-sema
 
+// A result is a list of links found by crawl, or the seeds.
+type result struct {
+	links []string
+	err   error
+}
+
 //!+
 func main() {
-	worklist := make(chan []string)
-	var n int // number of pending sends to worklist
+	start := time.Now()
+	seeds, err := links.CanonicalAll(os.Args[1:]) // as links.Extract returns them
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop() // a second interrupt kills the program
+	}()
+
+	worklist := make(chan result)
+	var n int       // number of pending sends to worklist
+	var errors int  // number of pages that could not be fetched
+	var skipped int // number of pages not fetched because of an interrupt
 
 	// Start with the command-line arguments.
 	// 为了使这个程序能够终止，我们需要在worklist为空或者没有crawl的goroutine在运行时退出主循环。
@@ -59,13 +100,22 @@ func main() {
 	/*
 		防止多个输入URL同时进入导致死锁
 	*/
-	go func() { worklist <- os.Args[1:] }()
+	go func() { worklist <- result{links: seeds} }()
 
 	// Crawl the web concurrently.
 	seen := make(map[string]bool)
 	for ; n > 0; n-- {
-		list := <-worklist
-		for _, link := range list {
+		r := <-worklist
+		if r.err == context.Canceled {
+			skipped++
+		} else if r.err != nil {
+			errors++
+		}
+		// 中断之后不再启动新的crawler，只等待正在进行的请求结束。
+		if ctx.Err() != nil {
+			continue
+		}
+		for _, link := range r.links {
 			if !seen[link] {
 				seen[link] = true
 				/*
@@ -77,11 +127,18 @@ func main() {
 				*/
 				n++
 				go func(link string) {
-					worklist <- crawl(link)
+					list, err := crawl(ctx, link)
+					worklist <- result{list, err}
 				}(link)
 			}
 		}
 	}
+
+	if ctx.Err() != nil {
+		log.Print("interrupted")
+	}
+	log.Printf("%d pages, %d errors, %d skipped, in %v",
+		len(seen)-errors-skipped, errors, skipped, time.Since(start).Round(time.Millisecond))
 }
 
 //!-
@@ -5,59 +5,199 @@
 
 // Crawl3 crawls web links starting with the command-line arguments.
 //
-// This version uses bounded parallelism.
-// For simplicity, it does not address the termination problem.
+// This version uses bounded parallelism, and stops when there is
+// nothing more to crawl, or on an interrupt, after the fetches under
+// way.  It then prints a summary of the crawl.
 //
+// It stays within -depth links of the arguments, on their hosts or
+// those matching -hosts, at URLs beginning with a -prefix, and visits
+// at most -max pages.  Unless -robots=false, it obeys the robots.txt
+// file of each host, including its Crawl-delay.  It puts URLs into
+// canonical form, and with -sortquery and -notrack, rewrites their
+// queries too, so that it fetches each page once.  It follows the
+// kinds of -links given, such as images and stylesheets too, and with
+// -nofollow, not those marked rel=nofollow.
+//
+// It makes at most -perhost concurrent requests to each host, at least
+// -interval apart, and with -stats, logs the queue of each host.
+//
+// With -checkpoint, it saves the state of the crawl to a file every
+// -every and when it stops, and with -resume, carries on from the
+// state saved there, without fetching again the pages it has fetched:
+//
+//	$ crawl3 -checkpoint crawl.json https://golang.org
+//	^C
+//	$ crawl3 -checkpoint crawl.json -resume
+//
+// With -format dot, json or csv, instead of listing the pages as it
+// goes, it writes the link graph of the crawl at the end, as Graphviz
+// DOT, newline-delimited JSON, or a CSV edge list.  Either way, it
+// then reports the broken links, and the pages that refer to them.
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	"gopl.io/ch5/links"
+	"gopl.io/ch8/crawler"
+)
+
+var (
+	depth    = flag.Int("depth", 3, "follow links at most this deep (0 means no limit)")
+	hosts    = flag.String("hosts", "", "comma-separated `patterns` of the hosts to crawl (default: the hosts of the arguments)")
+	prefixes = flag.String("prefix", "", "comma-separated `prefixes` of the URLs to crawl")
+	maxPages = flag.Int("max", 1000, "visit at most this many pages (0 means no limit)")
+	obey     = flag.Bool("robots", true, "obey robots.txt")
+)
+
+// Query rewriting, so that more URLs of the same page compare equal.
+var (
+	sortQuery = flag.Bool("sortquery", false, "sort the query parameters of URLs")
+	noTrack   = flag.Bool("notrack", false, "remove tracking parameters, such as utm_source, from URLs")
+)
+
+var (
+	kinds    = flag.String("links", "anchor", "comma-separated `kinds` of link to follow: anchor, stylesheet, script, image, canonical, frame, refresh, or all")
+	noFollow = flag.Bool("nofollow", false, "do not follow links marked rel=nofollow")
 )
 
-func crawl(url string) []string {
-	fmt.Println(url)
-	list, err := links.Extract(url)
-	if err != nil {
-		log.Print(err)
+var (
+	perHost  = flag.Int("perhost", 2, "concurrent requests to each host")
+	interval = flag.Duration("interval", 0, "minimum time between requests to each host")
+	stats    = flag.Duration("stats", 0, "log the queue of each host at this `interval` (0 means never)")
+)
+
+var (
+	checkpoint = flag.String("checkpoint", "", "save the state of the crawl to `file`")
+	every      = flag.Duration("every", time.Minute, "how often to save the state of the crawl")
+	resume     = flag.Bool("resume", false, "carry on from the state saved in the -checkpoint file")
+)
+
+var format = flag.String("format", "text", "output `format`: text, dot, json or csv")
+
+const agent = "gopl-crawl3"
+
+// scope returns the scope of the crawl given by the flags.
+func scope(seeds []string) crawler.Scope {
+	s := crawler.Scope{MaxDepth: *depth}
+	if *hosts != "" {
+		s.Hosts = strings.Split(*hosts, ",")
+	} else if *prefixes == "" {
+		for _, seed := range seeds {
+			if u, err := url.Parse(seed); err == nil {
+				s.Hosts = append(s.Hosts, u.Hostname())
+			}
+		}
+	}
+	if *prefixes != "" {
+		s.Prefixes = strings.Split(*prefixes, ",")
 	}
-	return list
+	return s
 }
 
 //!+
 func main() {
-	worklist := make(chan []string)  // lists of URLs, may have duplicates
-	unseenLinks := make(chan string) // de-duplicated URLs
-
-	// Add command-line arguments to worklist.
-	go func() { worklist <- os.Args[1:] }()
-
-	// Create 20 crawler goroutines to fetch each unseen link.
-	// 用了20个常驻的crawler goroutine，这样来保证最多20个HTTP请求在并发。
-	for i := 0; i < 20; i++ {
-		go func() {
-			for link := range unseenLinks {
-				foundLinks := crawl(link)
-				go func() { worklist <- foundLinks }()
+	flag.Parse()
+	writeGraph := map[string]func(*crawler.Graph, io.Writer) error{
+		"text": nil,
+		"dot":  (*crawler.Graph).WriteDOT,
+		"json": (*crawler.Graph).WriteJSON,
+		"csv":  (*crawler.Graph).WriteCSV,
+	}
+	write, ok := writeGraph[*format]
+	follow, err := links.ParseKind(*kinds)
+	if !ok || err != nil || (*resume && *checkpoint == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	seeds := flag.Args()
+	var saved *crawler.Checkpoint
+	if *resume {
+		saved, err = crawler.ReadCheckpoint(*checkpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		seeds = append(saved.Seeds, seeds...)
+	}
+
+	var graph crawler.Graph
+	c := &crawler.Crawler{
+		Scope:    scope(seeds),
+		Workers:  20, // 用了20个常驻的crawler goroutine，这样来保证最多20个HTTP请求在并发。
+		MaxPages: *maxPages,
+		Hosts:    &crawler.Scheduler{PerHost: *perHost, Interval: *interval},
+		Agent:    agent,
+		Canonical: links.Canonicalizer{
+			SortQuery:     *sortQuery,
+			StripTracking: *noTrack,
+		},
+		Links: links.Options{Kinds: follow, NoFollow: *noFollow},
+		Visit: func(p *crawler.Page) {
+			graph.Add(p)
+			if p.Err != nil {
+				log.Printf("%s: %v", p.URL, p.Err)
+			} else if write == nil {
+				fmt.Println(p.URL)
 			}
-		}()
-	}
-
-	// The main goroutine de-duplicates worklist items
-	// and sends the unseen ones to the crawlers.
-	seen := make(map[string]bool)
-	for list := range worklist {
-		for _, link := range list {
-			if !seen[link] {
-				seen[link] = true
-				// 向未查询的 channel 送入数据，唤醒阻塞的 20 个常驻协程
-				unseenLinks <- link
+		},
+	}
+	if *obey {
+		c.Robots = &crawler.Robots{Agent: agent}
+	}
+	if *checkpoint != "" {
+		c.Resume = saved
+		c.SaveEvery = *every
+		c.Save = func(cp *crawler.Checkpoint) {
+			if err := cp.WriteFile(*checkpoint); err != nil {
+				log.Print(err)
 			}
 		}
 	}
+
+	// An interrupt cancels ctx; the crawl then stops once the fetches
+	// under way are done.  A second interrupt kills the program.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop() // restore the default behaviour of interrupts
+	}()
+	if *stats > 0 {
+		go logStats(c.Hosts, *stats)
+	}
+	summary := c.Crawl(ctx, flag.Args())
+	if ctx.Err() != nil {
+		log.Print("interrupted")
+	}
+	if write != nil {
+		if err := write(&graph, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, b := range graph.Broken() {
+		log.Printf("broken link: %s (%d), referred to by %s",
+			b.URL, b.Status, strings.Join(b.Referrers, ", "))
+	}
+	log.Print(summary)
 }
 
 //!-
+
+// logStats logs the hosts with work, every period.
+func logStats(hosts *crawler.Scheduler, period time.Duration) {
+	for range time.Tick(period) {
+		for _, h := range hosts.Stats() {
+			if h.Queued+h.Active > 0 {
+				log.Printf("%s: %d queued, %d active, %d done", h.Host, h.Queued, h.Active, h.Done)
+			}
+		}
+	}
+}
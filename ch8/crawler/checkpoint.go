@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// A Checkpoint is the state of a crawl, from which another can carry
+// on: the URLs it has seen, and what became of each.
+type Checkpoint struct {
+	Seeds []string   `json:"seeds"`
+	URLs  []URLState `json:"urls"`
+}
+
+// URLState is the state of one URL in a crawl.
+type URLState struct {
+	URL    string `json:"url"`
+	Depth  int    `json:"depth"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// A Status is what became of a URL.
+type Status string
+
+const (
+	Queued     Status = "queued" // not yet fetched: part of the frontier
+	Fetched    Status = "ok"
+	Failed     Status = "error"
+	Disallowed Status = "disallowed" // by robots.txt
+)
+
+// Frontier returns the URLs of cp that are still to be fetched.
+func (cp *Checkpoint) Frontier() []URLState {
+	var frontier []URLState
+	for _, s := range cp.URLs {
+		if s.Status == Queued {
+			frontier = append(frontier, s)
+		}
+	}
+	return frontier
+}
+
+// checkpoint returns the checkpoint of a crawl from seeds whose URLs
+// are in states.
+func checkpoint(seeds []string, states map[string]*URLState) *Checkpoint {
+	cp := &Checkpoint{Seeds: seeds}
+	for _, s := range states {
+		cp.URLs = append(cp.URLs, *s)
+	}
+	sort.Slice(cp.URLs, func(i, j int) bool { return cp.URLs[i].URL < cp.URLs[j].URL })
+	return cp
+}
+
+// ReadCheckpoint reads a checkpoint file.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, &os.PathError{Op: "parse", Path: filename, Err: err}
+	}
+	return &cp, nil
+}
+
+// WriteFile writes cp to a file.  It writes a temporary file and then
+// renames it, so that the file is never left half written.
+func (cp *Checkpoint) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(cp, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
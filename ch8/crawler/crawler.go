@@ -0,0 +1,293 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopl.io/ch5/links"
+)
+
+// A Crawler crawls the web from a set of seed URLs.
+type Crawler struct {
+	Scope    Scope
+	Robots   *Robots // nil means robots.txt is ignored
+	Workers  int     // number of concurrent fetches; 0 means 20
+	MaxPages int     // pages to fetch at most; 0 means no limit
+
+	// Hosts schedules the fetches from each host.  Nil means a
+	// Scheduler with its defaults.  A Scheduler serves one crawl.
+	Hosts *Scheduler
+
+	// Agent is sent as the User-Agent of each request.
+	Agent string
+
+	// Canonical puts the seeds and the links found into canonical form,
+	// so that each page is fetched once, and the prefixes of Scope too,
+	// so that they match.  Its fields rewrite queries.
+	Canonical links.Canonicalizer
+
+	// Links selects the links of each page to follow.  The zero value
+	// follows anchors.
+	Links links.Options
+
+	// Fetch fetches p.URL, and fills in the other fields of p but for
+	// Depth and Latency, giving up with the error of ctx once it is
+	// cancelled.  Nil means an HTTP GET request, after which the links
+	// of an HTML page are extracted by links.ParseLinks.
+	Fetch func(ctx context.Context, p *Page)
+
+	// Visit, if not nil, is called with each page fetched, one at a time.
+	Visit func(p *Page)
+
+	// Resume, if not nil, is the checkpoint of an earlier crawl to
+	// carry on from.  Its frontier is fetched, and the other URLs it
+	// has seen are not fetched again.
+	Resume *Checkpoint
+
+	// Save, if not nil, is called with a checkpoint of the crawl every
+	// SaveEvery (0 means a minute), and when the crawl ends.
+	Save      func(cp *Checkpoint)
+	SaveEvery time.Duration
+}
+
+// A Page is the outcome of fetching one URL.
+type Page struct {
+	URL         string
+	Depth       int      // links followed from a seed
+	Links       []string // the links found on the page
+	Status      int      // HTTP status code, or 0 if there was no response
+	ContentType string
+	Latency     time.Duration // time taken to fetch the page
+	Err         error
+}
+
+// ErrDisallowed is the error of a page that robots.txt forbids.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// A Summary describes a crawl.
+type Summary struct {
+	Pages      int // pages fetched without error
+	Errors     int // pages that could not be fetched
+	Disallowed int // pages not fetched because of robots.txt
+	Elapsed    time.Duration
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d pages, %d errors, %d disallowed by robots.txt, in %v",
+		s.Pages, s.Errors, s.Disallowed, s.Elapsed.Round(time.Millisecond))
+}
+
+// Crawl crawls the web from seeds, and returns when there is nothing
+// more to fetch, or, once ctx is cancelled, when the fetches under way
+// have finished.
+func (c *Crawler) Crawl(ctx context.Context, seeds []string) Summary {
+	start := time.Now()
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 20
+	}
+	hosts := c.Hosts
+	if hosts == nil {
+		hosts = &Scheduler{}
+	}
+
+	// The workers take the URLs to fetch from the scheduler, until
+	// the crawl is over.
+	over, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *Page) // the pages fetched
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				url, err := hosts.Next(over)
+				if err != nil {
+					return
+				}
+				p := c.fetch(ctx, hosts, url)
+				hosts.Done(Host(url))
+				results <- p
+			}
+		}()
+	}
+
+	// The main goroutine de-duplicates links into the scheduler, and
+	// counts those pending, so that it can tell when the crawl is over.
+	states := make(map[string]*URLState) // the state of each URL seen
+	pending := 0                         // URLs pushed but not yet fetched
+	scope := c.Scope.canonical(&c.Canonical)
+	admit := func(rawurl string, depth int) {
+		url, err := c.Canonical.Canonical(rawurl)
+		if err != nil || states[url] != nil || !scope.Allows(url, depth) {
+			return
+		}
+		if c.MaxPages > 0 && len(states) == c.MaxPages {
+			return
+		}
+		states[url] = &URLState{URL: url, Depth: depth, Status: Queued}
+		if ctx.Err() == nil { // once stopped, it stays in the frontier
+			hosts.Push(url)
+			pending++
+		}
+	}
+	if c.Resume != nil {
+		seeds = union(c.Resume.Seeds, seeds)
+		for _, s := range c.Resume.URLs {
+			if s.Status != Queued {
+				s := s
+				states[s.URL] = &s
+			}
+		}
+		for _, s := range c.Resume.Frontier() {
+			admit(s.URL, s.Depth)
+		}
+	}
+	for _, seed := range seeds {
+		admit(seed, 0)
+	}
+
+	var tick <-chan time.Time
+	if c.Save != nil {
+		every := c.SaveEvery
+		if every <= 0 {
+			every = time.Minute
+		}
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var summary Summary
+	done := ctx.Done()
+	for pending > 0 {
+		select {
+		case p := <-results:
+			pending--
+			state := states[p.URL]
+			p.Depth = state.Depth
+			if ctx.Err() != nil && errors.Is(p.Err, ctx.Err()) {
+				continue // interrupted; it stays in the frontier
+			}
+			switch {
+			case p.Err == ErrDisallowed:
+				summary.Disallowed++
+				state.Status = Disallowed
+			case p.Err != nil:
+				summary.Errors++
+				state.Status, state.Error = Failed, p.Err.Error()
+			default:
+				summary.Pages++
+				state.Status = Fetched
+			}
+			if c.Visit != nil {
+				c.Visit(p)
+			}
+			for _, url := range p.Links {
+				admit(url, p.Depth+1)
+			}
+		case <-done:
+			// Stop: drop the queued URLs and wait for the fetches.
+			done = nil
+			pending -= len(hosts.Drop())
+		case <-tick:
+			c.Save(checkpoint(seeds, states))
+		}
+	}
+	if c.Save != nil {
+		c.Save(checkpoint(seeds, states))
+	}
+
+	summary.Elapsed = time.Since(start)
+	return summary
+}
+
+// fetch fetches a page, if robots.txt allows it, and passes the
+// Crawl-delay of its host to hosts.
+func (c *Crawler) fetch(ctx context.Context, hosts *Scheduler, rawurl string) *Page {
+	p := &Page{URL: rawurl}
+	if c.Robots != nil {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			p.Err = err
+			return p
+		}
+		rules, err := c.Robots.Rules(ctx, u)
+		if err != nil {
+			p.Err = err
+			return p
+		}
+		hosts.SetInterval(u.Host, rules.Delay)
+		if !rules.Allowed(u.RequestURI()) {
+			p.Err = ErrDisallowed
+			return p
+		}
+	}
+	fetch := c.Fetch
+	if fetch == nil {
+		fetch = c.get
+	}
+	start := time.Now()
+	fetch(ctx, p)
+	p.Latency = time.Since(start)
+	for i, link := range p.Links {
+		if url, err := c.Canonical.Canonical(link); err == nil {
+			p.Links[i] = url
+		}
+	}
+	return p
+}
+
+// get makes a GET request for p.URL, and if the response is an HTML
+// page, extracts its links.
+func (c *Crawler) get(ctx context.Context, p *Page) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL, nil)
+	if err != nil {
+		p.Err = err
+		return
+	}
+	if c.Agent != "" {
+		req.Header.Set("User-Agent", c.Agent)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		p.Err = err
+		return
+	}
+	defer resp.Body.Close()
+	p.Status = resp.StatusCode
+	p.ContentType = resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK {
+		p.Err = fmt.Errorf("getting %s: %s", p.URL, resp.Status)
+		return
+	}
+	if t, _, _ := mime.ParseMediaType(p.ContentType); t != "text/html" && t != "application/xhtml+xml" && t != "" {
+		return // not HTML
+	}
+	found, err := links.ParseLinks(resp.Request.URL, resp.Body, &c.Links)
+	if err != nil {
+		p.Err = fmt.Errorf("parsing %s as HTML: %w", p.URL, err)
+		return
+	}
+	for _, link := range found {
+		p.Links = append(p.Links, link.URL)
+	}
+}
+
+// union returns the strings of x and then those of y not in x.
+func union(x, y []string) []string {
+	z := append([]string(nil), x...)
+	in := make(map[string]bool)
+	for _, s := range x {
+		in[s] = true
+	}
+	for _, s := range y {
+		if !in[s] {
+			z = append(z, s)
+		}
+	}
+	return z
+}
@@ -0,0 +1,317 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pages is a synthetic web site: a map from each page to the pages it
+// links to.  Paths beginning /private/ are disallowed by robots.txt.
+var pages = map[string][]string{
+	"/":                     {"/a", "/b", "/private/x", "http://elsewhere.example/", "/a#top", "/b?"},
+	"/a":                    {"/", "/a/1", "/a/2"},
+	"/a/1":                  {"/a/1/deep"},
+	"/a/1/deep":             {"/a/1/deep/deeper"},
+	"/a/2":                  {"/b"},
+	"/b":                    {"/b/1", "/missing"},
+	"/b/1":                  {"/"},
+	"/private/x":            {"/private/y"},
+	"/private/public.html":  nil,
+	"/a/1/deep/deeper":      nil,
+	"/private/y":            nil,
+	"/private/unreferenced": nil,
+	"/c":                    {"/c#self", "/x/../c", "/c?utm_source=self"},
+}
+
+// hits counts the requests for each path.
+type hits struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func (h *hits) count(path string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.n[path]
+}
+
+// siteServer serves pages, and robots.txt with the given status.
+func siteServer(t *testing.T, robots string, robotsStatus int) (*httptest.Server, *hits) {
+	h := &hits{n: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.n[r.URL.Path]++
+		h.mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(robotsStatus)
+			fmt.Fprint(w, robots)
+			return
+		}
+		targets, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><body><h1>%s</h1>\n", r.URL.Path)
+		for _, target := range targets {
+			fmt.Fprintf(w, "<a href=%q>%s</a>\n", target, target)
+		}
+		fmt.Fprint(w, "</body></html>\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, h
+}
+
+// crawl crawls the site from root with c, and returns the paths of the
+// pages it fetches or fails to fetch, and the summary.
+func crawl(ctx context.Context, c *Crawler, root string) (string, Summary) {
+	var paths []string
+	visit := c.Visit
+	c.Visit = func(p *Page) {
+		if p.Err != ErrDisallowed {
+			u, _ := url.Parse(p.URL)
+			paths = append(paths, u.Path)
+		}
+		if visit != nil {
+			visit(p)
+		}
+	}
+	summary := c.Crawl(ctx, []string{root})
+	sort.Strings(paths)
+	return strings.Join(paths, " "), summary
+}
+
+func TestCrawl(t *testing.T) {
+	srv, hits := siteServer(t, robotsTxt, http.StatusOK)
+
+	c := &Crawler{
+		Scope:  Scope{MaxDepth: 3, Hosts: []string{"127.0.0.1"}},
+		Robots: &Robots{Agent: "gopl-test"},
+	}
+	got, summary := crawl(context.Background(), c, srv.URL+"/")
+	want := "/ /a /a/1 /a/1/deep /a/2 /b /b/1 /missing"
+	if got != want {
+		t.Errorf("visited %s, want %s", got, want)
+	}
+	if summary.Pages != 7 || summary.Errors != 1 || summary.Disallowed != 1 {
+		t.Errorf("summary: %v", summary)
+	}
+	if n := hits.count("/robots.txt"); n != 1 {
+		t.Errorf("robots.txt fetched %d times, want once", n)
+	}
+
+	c = &Crawler{Scope: Scope{Prefixes: []string{srv.URL + "/a"}}}
+	got, _ = crawl(context.Background(), c, srv.URL+"/a")
+	want = "/a /a/1 /a/1/deep /a/1/deep/deeper /a/2"
+	if got != want {
+		t.Errorf("visited %s, want %s", got, want)
+	}
+
+	c = &Crawler{MaxPages: 3, Workers: 1}
+	got, summary = crawl(context.Background(), c, srv.URL+"/")
+	want = "/ /a /b"
+	if got != want || summary.Pages != 3 {
+		t.Errorf("visited %s (%v), want %s", got, summary, want)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv, _ := siteServer(t, "User-agent: *\nCrawl-delay: 10\n", http.StatusOK)
+
+	// Cancelling the crawl stops it at once, even when its workers
+	// are waiting out a Crawl-delay.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &Crawler{
+		Robots:  &Robots{},
+		Workers: 4,
+		Visit: func(p *Page) {
+			if p.Err == nil {
+				cancel()
+			}
+		},
+	}
+	start := time.Now()
+	got, summary := crawl(ctx, c, srv.URL+"/")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled crawl took %v", elapsed)
+	}
+	if got != "/" || summary.Pages != 1 {
+		t.Errorf("visited %s (%v), want /", got, summary)
+	}
+}
+
+func TestCancelHung(t *testing.T) {
+	for _, hung := range []string{"/hang", "/robots.txt"} {
+		// The server answers / at once, and hung only once the
+		// client has given up.
+		started := make(chan struct{}, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == hung {
+				started <- struct{}{}
+				<-r.Context().Done()
+				return
+			}
+			if r.URL.Path == "/" {
+				fmt.Fprint(w, `<a href="/hang">hang</a>`)
+			}
+		}))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		var cp *Checkpoint
+		c := &Crawler{Robots: &Robots{}, Save: func(c *Checkpoint) { cp = c }}
+		start := time.Now()
+		c.Crawl(ctx, []string{srv.URL + "/"})
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: interrupted crawl took %v", hung, elapsed)
+		}
+		// The interrupted fetch is left to a resumed crawl.
+		var frontier []string
+		for _, s := range cp.Frontier() {
+			frontier = append(frontier, strings.TrimPrefix(s.URL, srv.URL))
+		}
+		want := "/hang"
+		if hung == "/robots.txt" {
+			want = "/"
+		}
+		if got := strings.Join(frontier, " "); got != want {
+			t.Errorf("%s: frontier after interrupt = %q, want %q", hung, got, want)
+		}
+		cancel()
+		srv.Close()
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	srv, hits := siteServer(t, "User-agent: *\nCrawl-delay: 0.1\n", http.StatusOK)
+
+	c := &Crawler{
+		Scope:   Scope{Prefixes: []string{srv.URL + "/a"}},
+		Robots:  &Robots{},
+		Workers: 4,
+	}
+	start := time.Now()
+	_, summary := crawl(context.Background(), c, srv.URL+"/a")
+	// The first request goes at once and the others 0.1s apart.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("5 pages took %v, want at least 400ms", elapsed)
+	}
+	if summary.Pages != 5 {
+		t.Errorf("summary: %v", summary)
+	}
+	if n := hits.count("/robots.txt"); n != 1 {
+		t.Errorf("robots.txt fetched %d times, want once", n)
+	}
+}
+
+func TestResume(t *testing.T) {
+	srv, hits := siteServer(t, robotsTxt, http.StatusOK)
+	filename := filepath.Join(t.TempDir(), "crawl.json")
+	save := func(cp *Checkpoint) {
+		if err := cp.WriteFile(filename); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Interrupt a crawl after three pages.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited := 0
+	c := &Crawler{
+		Scope:   Scope{Hosts: []string{"127.0.0.1"}},
+		Robots:  &Robots{},
+		Workers: 1,
+		Visit: func(p *Page) {
+			if visited++; visited == 3 {
+				cancel()
+			}
+		},
+		Save: save,
+	}
+	c.Crawl(ctx, []string{srv.URL + "/"})
+
+	cp, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Seeds) != 1 || len(cp.Frontier()) == 0 {
+		t.Fatalf("checkpoint has seeds %v and frontier %v", cp.Seeds, cp.Frontier())
+	}
+
+	// Carry on, without the seeds.
+	c = &Crawler{
+		Scope:   Scope{Hosts: []string{"127.0.0.1"}},
+		Robots:  &Robots{},
+		Resume:  cp,
+		Save:    save,
+		Workers: 1,
+	}
+	c.Crawl(context.Background(), nil)
+
+	// Every page has been fetched once.
+	for path := range pages {
+		want := 1
+		if strings.HasPrefix(path, "/private/") || path == "/c" {
+			want = 0 // disallowed, or not linked to
+		}
+		if n := hits.count(path); n != want {
+			t.Errorf("%s fetched %d times, want %d", path, n, want)
+		}
+	}
+	cp, err = ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Frontier()) != 0 {
+		t.Errorf("frontier of a finished crawl: %v", cp.Frontier())
+	}
+	status := make(map[string]Status)
+	for _, s := range cp.URLs {
+		status[strings.TrimPrefix(s.URL, srv.URL)] = s.Status
+	}
+	for path, want := range map[string]Status{
+		"/":          Fetched,
+		"/a/1/deep":  Fetched,
+		"/missing":   Failed,
+		"/private/x": Disallowed,
+	} {
+		if status[path] != want {
+			t.Errorf("status of %s = %q, want %q", path, status[path], want)
+		}
+	}
+}
+
+func TestCanonicalLinks(t *testing.T) {
+	srv, hits := siteServer(t, "", http.StatusNotFound)
+
+	c := &Crawler{Scope: Scope{Prefixes: []string{srv.URL + "/c"}}}
+	_, summary := crawl(context.Background(), c, srv.URL+"/c")
+	if summary.Pages != 2 || hits.count("/c") != 2 {
+		t.Errorf("fetched %d pages, /c %d times, want 2 and 2", summary.Pages, hits.count("/c"))
+	}
+
+	c.Canonical.StripTracking = true
+	_, summary = crawl(context.Background(), c, srv.URL+"/c?utm_medium=seed#top")
+	if summary.Pages != 1 || hits.count("/c") != 3 {
+		t.Errorf("fetched %d pages, /c %d times, want 1 and 3", summary.Pages, hits.count("/c"))
+	}
+
+	// The prefixes of the scope are put into canonical form too.
+	c = &Crawler{Scope: Scope{Prefixes: []string{"HTTP" + strings.TrimPrefix(srv.URL, "http") + "/x/../c"}}}
+	_, summary = crawl(context.Background(), c, srv.URL+"/c")
+	if summary.Pages != 2 {
+		t.Errorf("fetched %d pages with a non-canonical prefix, want 2", summary.Pages)
+	}
+}
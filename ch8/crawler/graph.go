@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A Graph is the link graph of a crawl: the pages fetched, and the
+// links from each to others.  Add each page to it as it is visited.
+type Graph struct {
+	pages map[string]*Page    // the pages fetched, by URL
+	refs  map[string][]string // the pages that link to each URL
+}
+
+// Add adds a page and its links to g.
+func (g *Graph) Add(p *Page) {
+	if g.pages == nil {
+		g.pages = make(map[string]*Page)
+		g.refs = make(map[string][]string)
+	}
+	g.pages[p.URL] = p
+	for _, to := range targets(p) {
+		g.refs[to] = append(g.refs[to], p.URL)
+	}
+}
+
+// targets returns the links of p, without duplicates.
+func targets(p *Page) []string {
+	var list []string
+	seen := make(map[string]bool)
+	for _, link := range p.Links {
+		if !seen[link] {
+			seen[link] = true
+			list = append(list, link)
+		}
+	}
+	return list
+}
+
+// sorted returns the pages of g in order of URL.
+func (g *Graph) sorted() []*Page {
+	var pages []*Page
+	for _, p := range g.pages {
+		pages = append(pages, p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+	return pages
+}
+
+// A BrokenLink is a page whose fetch failed with an HTTP error status,
+// and the pages that link to it.
+type BrokenLink struct {
+	URL       string
+	Status    int
+	Referrers []string
+}
+
+// Broken returns the broken links of g, in order of URL.
+func (g *Graph) Broken() []BrokenLink {
+	var broken []BrokenLink
+	for _, p := range g.sorted() {
+		if p.Status >= 400 {
+			broken = append(broken, BrokenLink{p.URL, p.Status, g.refs[p.URL]})
+		}
+	}
+	return broken
+}
+
+// WriteDOT writes g in the Graphviz DOT language.  Broken links are
+// red, and pages not fetched dashed.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph crawl {")
+	fmt.Fprintln(out, "\tnode [shape=box];")
+	for _, p := range g.sorted() {
+		tooltip := fmt.Sprintf("%d %s %v", p.Status, p.ContentType, p.Latency.Round(time.Microsecond))
+		if p.Status == 0 && p.Err != nil {
+			tooltip = p.Err.Error()
+		}
+		attrs := fmt.Sprintf("tooltip=%q", tooltip)
+		if p.Status >= 400 || (p.Err != nil && p.Err != ErrDisallowed) {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(out, "\t%q [%s];\n", p.URL, attrs)
+	}
+	var unfetched []string
+	for to := range g.refs {
+		if g.pages[to] == nil {
+			unfetched = append(unfetched, to)
+		}
+	}
+	sort.Strings(unfetched)
+	for _, to := range unfetched {
+		fmt.Fprintf(out, "\t%q [style=dashed];\n", to)
+	}
+	for _, p := range g.sorted() {
+		for _, to := range targets(p) {
+			fmt.Fprintf(out, "\t%q -> %q;\n", p.URL, to)
+		}
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// WriteJSON writes g as newline-delimited JSON: an object for each
+// page fetched, with its links.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	type page struct {
+		URL         string   `json:"url"`
+		Depth       int      `json:"depth"`
+		Status      int      `json:"status,omitempty"`
+		ContentType string   `json:"content_type,omitempty"`
+		LatencyMS   float64  `json:"latency_ms"`
+		Error       string   `json:"error,omitempty"`
+		Links       []string `json:"links,omitempty"`
+	}
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+	for _, p := range g.sorted() {
+		v := page{
+			URL:         p.URL,
+			Depth:       p.Depth,
+			Status:      p.Status,
+			ContentType: p.ContentType,
+			LatencyMS:   p.Latency.Seconds() * 1000,
+			Links:       targets(p),
+		}
+		if p.Err != nil {
+			v.Error = p.Err.Error()
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// WriteCSV writes g as a CSV edge list, with the status, content type
+// and latency of the page linked to, if it was fetched with a response.
+func (g *Graph) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"from", "to", "status", "content_type", "latency_ms"})
+	for _, p := range g.sorted() {
+		for _, to := range targets(p) {
+			record := []string{p.URL, to, "", "", ""}
+			if q := g.pages[to]; q != nil && q.Status != 0 {
+				record[2] = strconv.Itoa(q.Status)
+				record[3] = q.ContentType
+				record[4] = strconv.FormatFloat(q.Latency.Seconds()*1000, 'f', 1, 64)
+			}
+			out.Write(record)
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
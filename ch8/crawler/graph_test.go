@@ -0,0 +1,113 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphCrawl(t *testing.T) {
+	srv, _ := siteServer(t, robotsTxt, http.StatusOK)
+	var g Graph
+	c := &Crawler{
+		Scope:  Scope{Hosts: []string{"127.0.0.1"}},
+		Robots: &Robots{},
+		Visit:  g.Add,
+	}
+	c.Crawl(context.Background(), []string{srv.URL + "/"})
+
+	broken := g.Broken()
+	want := fmt.Sprint([]BrokenLink{{srv.URL + "/missing", 404, []string{srv.URL + "/b"}}})
+	if fmt.Sprint(broken) != want {
+		t.Errorf("Broken() = %v, want %v", broken, want)
+	}
+	root := g.pages[srv.URL+"/"]
+	if root.Status != 200 || !strings.HasPrefix(root.ContentType, "text/html") || root.Latency <= 0 {
+		t.Errorf("root page: status %d, type %q, latency %v", root.Status, root.ContentType, root.Latency)
+	}
+}
+
+// graph returns a small graph with a broken link, a page that robots.txt
+// forbids, and a link off the site.
+func graph() *Graph {
+	var g Graph
+	for _, p := range []*Page{
+		{URL: "http://a/", Links: []string{"http://a/b", "http://a/c", "http://a/b", "http://x/"},
+			Status: 200, ContentType: "text/html", Latency: 12 * time.Millisecond},
+		{URL: "http://a/b", Depth: 1, Links: []string{"http://a/", "http://a/d"},
+			Status: 200, ContentType: "text/html", Latency: 3 * time.Millisecond},
+		{URL: "http://a/c", Depth: 1, Err: ErrDisallowed},
+		{URL: "http://a/d", Depth: 2, Status: 404, ContentType: "text/plain",
+			Latency: time.Millisecond, Err: errors.New("getting http://a/d: 404 Not Found")},
+		{URL: "http://a/e", Depth: 1}, // neither status nor error
+	} {
+		g.Add(p)
+	}
+	return &g
+}
+
+func TestWriteDOT(t *testing.T) {
+	var out strings.Builder
+	if err := graph().WriteDOT(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph crawl {
+	node [shape=box];
+	"http://a/" [tooltip="200 text/html 12ms"];
+	"http://a/b" [tooltip="200 text/html 3ms"];
+	"http://a/c" [tooltip="disallowed by robots.txt"];
+	"http://a/d" [tooltip="404 text/plain 1ms", color=red];
+	"http://a/e" [tooltip="0  0s"];
+	"http://x/" [style=dashed];
+	"http://a/" -> "http://a/b";
+	"http://a/" -> "http://a/c";
+	"http://a/" -> "http://x/";
+	"http://a/b" -> "http://a/";
+	"http://a/b" -> "http://a/d";
+}
+`
+	if out.String() != want {
+		t.Errorf("WriteDOT:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out strings.Builder
+	if err := graph().WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"url":"http://a/","depth":0,"status":200,"content_type":"text/html","latency_ms":12,"links":["http://a/b","http://a/c","http://x/"]}
+{"url":"http://a/b","depth":1,"status":200,"content_type":"text/html","latency_ms":3,"links":["http://a/","http://a/d"]}
+{"url":"http://a/c","depth":1,"latency_ms":0,"error":"disallowed by robots.txt"}
+{"url":"http://a/d","depth":2,"status":404,"content_type":"text/plain","latency_ms":1,"error":"getting http://a/d: 404 Not Found"}
+{"url":"http://a/e","depth":1,"latency_ms":0}
+`
+	if out.String() != want {
+		t.Errorf("WriteJSON:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var out strings.Builder
+	if err := graph().WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `from,to,status,content_type,latency_ms
+http://a/,http://a/b,200,text/html,3.0
+http://a/,http://a/c,,,
+http://a/,http://x/,,,
+http://a/b,http://a/,200,text/html,12.0
+http://a/b,http://a/d,404,text/plain,1.0
+`
+	if out.String() != want {
+		t.Errorf("WriteCSV:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if broken := graph().Broken(); len(broken) != 1 || broken[0].URL != "http://a/d" ||
+		fmt.Sprint(broken[0].Referrers) != "[http://a/b]" {
+		t.Errorf("Broken() = %v", broken)
+	}
+}
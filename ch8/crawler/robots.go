@@ -0,0 +1,239 @@
+package crawler
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rules are the rules of a robots.txt file that apply to one crawler.
+type Rules struct {
+	rules []rule
+	Delay time.Duration // the Crawl-delay: minimum time between requests
+}
+
+// A rule is an Allow or Disallow line.
+type rule struct {
+	allow   bool
+	path    string
+	pattern *regexp.Regexp
+}
+
+// AllowAll and DisallowAll are the rules that apply when a host has
+// no robots.txt file, and when it cannot be fetched.
+var (
+	AllowAll    = &Rules{}
+	DisallowAll = &Rules{rules: []rule{{false, "/", regexp.MustCompile("^/")}}}
+)
+
+// ParseRobots parses a robots.txt file and returns the rules for the
+// crawler named agent: those of the groups whose User-agent is a
+// part of agent, or if there are none, those for all crawlers (*).
+func ParseRobots(r io.Reader, agent string) (*Rules, error) {
+	var mine, all Rules
+	var haveMine bool
+	var group []*Rules // the rules that the current group adds to
+	inAgents := false  // the previous line was a User-agent line
+	agent = strings.ToLower(agent)
+
+	input := bufio.NewScanner(r)
+	for input.Scan() {
+		line := input.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		if key == "user-agent" {
+			if !inAgents {
+				group = nil // a new group
+			}
+			inAgents = true
+			token := strings.ToLower(value)
+			if token == "*" {
+				group = append(group, &all)
+			} else if token != "" && strings.Contains(agent, token) {
+				group = append(group, &mine)
+				haveMine = true
+			}
+			continue
+		}
+		inAgents = false
+		for _, rules := range group {
+			switch key {
+			case "allow", "disallow":
+				if value == "" {
+					continue // an empty Disallow allows everything
+				}
+				rules.rules = append(rules.rules, rule{key == "allow", value, compile(value)})
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs >= 0 {
+					rules.Delay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+	if err := input.Err(); err != nil {
+		return nil, err
+	}
+	if haveMine {
+		return &mine, nil
+	}
+	return &all, nil
+}
+
+// compile returns a regular expression for a path pattern, in which
+// * matches any characters and a final $ matches the end of the path.
+func compile(pattern string) *regexp.Regexp {
+	end := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if end {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Allowed reports whether the rules allow the crawler to fetch path,
+// which includes any query.  The longest matching rule applies, and
+// Allow wins a tie.
+func (r *Rules) Allowed(path string) bool {
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if n := len(rule.path); n > longest || (n == longest && rule.allow) {
+			allowed, longest = rule.allow, n
+		}
+	}
+	return allowed
+}
+
+// Robots fetches the robots.txt file of each host once, and applies
+// its rules.  A Scheduler applies their Crawl-delay.
+type Robots struct {
+	Agent  string       // the name of the crawler, sent as its User-Agent
+	Client *http.Client // nil means http.DefaultClient
+
+	mu    sync.Mutex
+	hosts map[string]*site // by scheme and host
+}
+
+// A site holds the rules of a host.
+type site struct {
+	rules *Rules
+	ready chan struct{} // closed when rules is set
+}
+
+// Allowed reports whether the crawler may fetch u.  It reports false
+// if ctx is cancelled before the rules of the host of u are known.
+func (r *Robots) Allowed(ctx context.Context, u *url.URL) bool {
+	rules, err := r.Rules(ctx, u)
+	return err == nil && rules.Allowed(u.RequestURI())
+}
+
+// Rules returns the rules of the host of u.  The only error is that of
+// ctx, if it is cancelled before they are known.
+func (r *Robots) Rules(ctx context.Context, u *url.URL) (*Rules, error) {
+	s, err := r.site(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return s.rules, nil
+}
+
+// site returns the site of u, fetching its robots.txt file if this is
+// the first request for it.  Concurrent requests for a new site wait
+// for the first.  If its fetch is cancelled, a later request tries
+// again.
+func (r *Robots) site(ctx context.Context, u *url.URL) (*site, error) {
+	key := u.Scheme + "://" + u.Host
+	for {
+		r.mu.Lock()
+		if r.hosts == nil {
+			r.hosts = make(map[string]*site)
+		}
+		s := r.hosts[key]
+		if s == nil {
+			s = &site{ready: make(chan struct{})}
+			r.hosts[key] = s
+			r.mu.Unlock()
+			rules, err := r.fetch(ctx, key+"/robots.txt")
+			if err != nil {
+				r.mu.Lock()
+				delete(r.hosts, key)
+				r.mu.Unlock()
+				close(s.ready) // with s.rules nil
+				return nil, err
+			}
+			s.rules = rules
+			close(s.ready)
+			return s, nil
+		}
+		r.mu.Unlock()
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if s.rules != nil {
+			return s, nil
+		}
+		// The fetch was cancelled; try again.
+	}
+}
+
+// fetch fetches and parses a robots.txt file.  As RFC 9309 says, a
+// missing file allows everything, and one that cannot be fetched
+// forbids everything.  The only error is that of ctx, if it is
+// cancelled first.
+func (r *Robots) fetch(ctx context.Context, robotsURL string) (*Rules, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", robotsURL, nil)
+	if err != nil {
+		return DisallowAll, nil
+	}
+	if r.Agent != "" {
+		req.Header.Set("User-Agent", r.Agent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return DisallowAll, nil
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return DisallowAll, nil
+	case resp.StatusCode >= 400:
+		return AllowAll, nil
+	case resp.StatusCode != http.StatusOK:
+		return DisallowAll, nil
+	}
+	rules, err := ParseRobots(io.LimitReader(resp.Body, 500<<10), r.Agent)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return DisallowAll, nil
+	}
+	return rules, nil
+}
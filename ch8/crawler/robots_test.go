@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const robotsTxt = `# A synthetic site.
+User-agent: otherbot
+Disallow: /
+
+User-agent: *
+Disallow: /private/
+Allow: /private/public.html
+Disallow: /*.pdf$
+Crawl-delay: 0.05
+
+User-agent: testbot
+User-agent: anotherbot
+Disallow: /cgi-bin/
+Disallow:
+`
+
+func TestParseRobots(t *testing.T) {
+	all, err := ParseRobots(strings.NewReader(robotsTxt), "Mozilla/5.0 (compatible; crawler)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mine, err := ParseRobots(strings.NewReader(robotsTxt), "TestBot/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Delay != 50*time.Millisecond || mine.Delay != 0 {
+		t.Errorf("Delay = %v and %v, want 50ms and 0", all.Delay, mine.Delay)
+	}
+	for _, test := range []struct {
+		rules *Rules
+		path  string
+		want  bool
+	}{
+		{all, "/", true},
+		{all, "/private/", false},
+		{all, "/private/secret.html", false},
+		{all, "/private/public.html", true},
+		{all, "/paper.pdf", false},
+		{all, "/paper.pdf?download=1", true},
+		{all, "/cgi-bin/search", true},
+		{mine, "/private/secret.html", true},
+		{mine, "/cgi-bin/search", false},
+		{DisallowAll, "/", false},
+		{AllowAll, "/private/", true},
+	} {
+		if got := test.rules.Allowed(test.path); got != test.want {
+			t.Errorf("Allowed(%q) = %t", test.path, got)
+		}
+	}
+}
+
+func TestRobotsStatus(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, true},
+		{http.StatusForbidden, true},
+		{http.StatusServiceUnavailable, false},
+	} {
+		srv, _ := siteServer(t, "User-agent: *\nDisallow: /private/\n", test.status)
+		u, _ := url.Parse(srv.URL + "/private/x")
+		if got := (&Robots{}).Allowed(context.Background(), u); got != test.want {
+			t.Errorf("robots.txt status %d: Allowed = %t", test.status, got)
+		}
+	}
+
+	// An unreachable host may not be crawled.
+	srv, _ := siteServer(t, "", http.StatusOK)
+	srv.Close()
+	u, _ := url.Parse(srv.URL + "/")
+	if (&Robots{}).Allowed(context.Background(), u) {
+		t.Errorf("unreachable host: Allowed = true")
+	}
+}
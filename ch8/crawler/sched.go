@@ -0,0 +1,261 @@
+package crawler
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and waits.  Tests use a fake one.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// A Scheduler decides when a crawler may make a request to each host.
+// It limits the concurrent requests to a host, and spaces them out.
+//
+// A crawler with a pool of workers queues URLs with Push, and each
+// worker takes the next with Next, which returns the URLs of ready
+// hosts in turn, so that a slow host holds up only its own queue.
+// A crawler with a goroutine for each URL calls Acquire instead.
+// Either way, it calls Done when the request is over.
+type Scheduler struct {
+	PerHost  int           // concurrent requests to each host; 0 means 2
+	Interval time.Duration // minimum time between requests to each host
+	Clock    Clock         // nil means the real clock
+
+	mu      sync.Mutex
+	hosts   map[string]*host
+	order   []*host       // the hosts, in the order Next tries them
+	turn    int           // index in order of the host Next tries first
+	changed chan struct{} // closed, and replaced, when a host may be ready
+}
+
+// A host is the state of one host.
+type host struct {
+	name     string
+	queue    []string      // URLs pushed but not yet returned by Next
+	waiting  int           // calls to Acquire under way
+	active   int           // requests under way
+	done     int           // requests over
+	interval time.Duration // the host's own minimum interval
+	last     time.Time     // start of the latest request
+}
+
+// HostStats describes the work for one host.
+type HostStats struct {
+	Host   string
+	Queued int // URLs queued, and goroutines waiting in Acquire
+	Active int // requests under way
+	Done   int // requests over
+}
+
+// Host returns the host, with any port, of a URL, the key by which a
+// Scheduler knows it.
+func Host(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func (s *Scheduler) clock() Clock {
+	if s.Clock == nil {
+		return realClock{}
+	}
+	return s.Clock
+}
+
+// host returns the state of the named host, creating it if need be.
+// s.mu must be held.
+func (s *Scheduler) host(name string) *host {
+	s.init()
+	h := s.hosts[name]
+	if h == nil {
+		h = &host{name: name}
+		s.hosts[name] = h
+		s.order = append(s.order, h)
+	}
+	return h
+}
+
+// init initializes s, if need be.  s.mu must be held.
+func (s *Scheduler) init() {
+	if s.hosts == nil {
+		s.hosts = make(map[string]*host)
+		s.changed = make(chan struct{})
+	}
+}
+
+// wake wakes the callers of Next and Acquire to look again.
+// s.mu must be held.
+func (s *Scheduler) wake() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// ready reports whether a request to h may start now, and if not, how
+// long until it may, or 0 if that depends on a request being Done.
+func (s *Scheduler) ready(h *host, now time.Time) (bool, time.Duration) {
+	perHost := s.PerHost
+	if perHost <= 0 {
+		perHost = 2
+	}
+	if h.active >= perHost {
+		return false, 0
+	}
+	interval := s.Interval
+	if h.interval > interval {
+		interval = h.interval
+	}
+	if next := h.last.Add(interval); !h.last.IsZero() && now.Before(next) {
+		return false, next.Sub(now)
+	}
+	return true, 0
+}
+
+// start records the start of a request to h.
+func (s *Scheduler) start(h *host, now time.Time) {
+	h.active++
+	h.last = now
+}
+
+// await calls try, with s.mu held, until it succeeds or ctx is
+// cancelled.  If try fails, it returns how long to wait before trying
+// again, or 0 to wait for a change.
+func (s *Scheduler) await(ctx context.Context, try func(now time.Time) (bool, time.Duration)) error {
+	for {
+		s.mu.Lock()
+		s.init()
+		ok, wait := try(s.clock().Now())
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timeout = s.clock().After(wait)
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Push queues a URL for Next.
+func (s *Scheduler) Push(url string) {
+	s.mu.Lock()
+	h := s.host(Host(url))
+	h.queue = append(h.queue, url)
+	s.wake()
+	s.mu.Unlock()
+}
+
+// Next blocks until a request may start to a host with a queued URL,
+// or ctx is cancelled, and returns the URL.  It takes the hosts in
+// turn.
+func (s *Scheduler) Next(ctx context.Context) (string, error) {
+	var next string
+	err := s.await(ctx, func(now time.Time) (bool, time.Duration) {
+		var soonest time.Duration
+		n := len(s.order)
+		for i := 0; i < n; i++ {
+			h := s.order[(s.turn+i)%n]
+			if len(h.queue) == 0 {
+				continue
+			}
+			ok, wait := s.ready(h, now)
+			if ok {
+				next, h.queue = h.queue[0], h.queue[1:]
+				s.start(h, now)
+				s.turn = (s.turn + i + 1) % n
+				return true, 0
+			}
+			if wait > 0 && (soonest == 0 || wait < soonest) {
+				soonest = wait
+			}
+		}
+		return false, soonest
+	})
+	return next, err
+}
+
+// Acquire blocks until a request to the host may start, or ctx is
+// cancelled.
+func (s *Scheduler) Acquire(ctx context.Context, host string) error {
+	s.mu.Lock()
+	h := s.host(host)
+	h.waiting++
+	s.mu.Unlock()
+
+	err := s.await(ctx, func(now time.Time) (bool, time.Duration) {
+		ok, wait := s.ready(h, now)
+		if ok {
+			h.waiting--
+			s.start(h, now)
+		}
+		return ok, wait
+	})
+	if err != nil {
+		s.mu.Lock()
+		h.waiting--
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Done records the end of a request to the host.
+func (s *Scheduler) Done(host string) {
+	s.mu.Lock()
+	h := s.host(host)
+	h.active--
+	h.done++
+	s.wake()
+	s.mu.Unlock()
+}
+
+// SetInterval sets the minimum time between requests to the host, if
+// it is longer than s.Interval: the Crawl-delay of its robots.txt, say.
+func (s *Scheduler) SetInterval(host string, d time.Duration) {
+	s.mu.Lock()
+	s.host(host).interval = d
+	s.wake()
+	s.mu.Unlock()
+}
+
+// Drop removes the queued URLs, and returns them.
+func (s *Scheduler) Drop() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dropped []string
+	for _, h := range s.order {
+		dropped = append(dropped, h.queue...)
+		h.queue = nil
+	}
+	return dropped
+}
+
+// Stats returns the statistics of the hosts, in order of name.
+func (s *Scheduler) Stats() []HostStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stats []HostStats
+	for _, h := range s.order {
+		stats = append(stats, HostStats{h.name, len(h.queue) + h.waiting, h.active, h.done})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
@@ -0,0 +1,201 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeClock is a Clock whose time moves only when told to.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := fakeTimer{c.now.Add(d), make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	return timer.c
+}
+
+// Advance moves the time on by d, and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var pending []fakeTimer
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// waitTimers waits until there are n timers pending.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no %d timers pending", n)
+}
+
+// next calls s.Next in a new goroutine, and returns a channel of its
+// result.
+func next(s *Scheduler) <-chan string {
+	ch := make(chan string, 1)
+	go func() {
+		url, err := s.Next(context.Background())
+		if err != nil {
+			url = err.Error()
+		}
+		ch <- url
+	}()
+	return ch
+}
+
+// expect receives the URL returned by Next, failing if it takes long.
+func expect(t *testing.T, ch <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Errorf("Next() = %s, want %s", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Next() blocked, want %s", want)
+	}
+}
+
+// blocked checks that Next has not returned.
+func blocked(t *testing.T, ch <-chan string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("Next() = %s, want it to block", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSchedulerPerHost(t *testing.T) {
+	s := &Scheduler{PerHost: 2, Clock: &fakeClock{}}
+	for i := 1; i <= 4; i++ {
+		s.Push(fmt.Sprintf("http://a/%d", i))
+	}
+	s.Push("http://b/1")
+
+	// The hosts take turns.
+	expect(t, next(s), "http://a/1")
+	expect(t, next(s), "http://b/1")
+	expect(t, next(s), "http://a/2")
+
+	// a has two requests under way, and b none queued.
+	ch := next(s)
+	blocked(t, ch)
+	s.Done("b")
+	blocked(t, ch)
+	s.Done("a")
+	expect(t, ch, "http://a/3")
+
+	want := []HostStats{{"a", 1, 2, 1}, {"b", 0, 0, 1}}
+	if got := s.Stats(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Stats() = %v, want %v", got, want)
+	}
+
+	// Drop empties the queues, and a cancelled Next gives up.
+	if got := s.Drop(); len(got) != 1 || got[0] != "http://a/4" {
+		t.Errorf("Drop() = %v", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Next(ctx); err != context.Canceled {
+		t.Errorf("Next(cancelled) returned %v", err)
+	}
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &Scheduler{Interval: 10 * time.Second, Clock: clock}
+	for i := 1; i <= 3; i++ {
+		s.Push(fmt.Sprintf("http://a/%d", i))
+	}
+	expect(t, next(s), "http://a/1")
+	s.Done("a")
+
+	ch := next(s)
+	clock.waitTimers(t, 1)
+	clock.Advance(5 * time.Second)
+	blocked(t, ch)
+	clock.Advance(5 * time.Second)
+	expect(t, ch, "http://a/2")
+	s.Done("a")
+
+	// A Crawl-delay longer than the interval takes its place.
+	s.SetInterval("a", time.Minute)
+	ch = next(s)
+	clock.waitTimers(t, 1)
+	clock.Advance(30 * time.Second)
+	blocked(t, ch)
+	clock.waitTimers(t, 1)
+	clock.Advance(30 * time.Second)
+	expect(t, ch, "http://a/3")
+}
+
+func TestSchedulerSlowHost(t *testing.T) {
+	// A slow host, with a request under way and a long interval,
+	// holds up only its own queue.
+	clock := &fakeClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &Scheduler{PerHost: 1, Clock: clock}
+	s.SetInterval("slow", time.Hour)
+	for i := 1; i <= 3; i++ {
+		s.Push(fmt.Sprintf("http://slow/%d", i))
+	}
+	expect(t, next(s), "http://slow/1")
+	for i := 1; i <= 3; i++ {
+		s.Push(fmt.Sprintf("http://fast/%d", i))
+	}
+	for i := 1; i <= 3; i++ {
+		expect(t, next(s), fmt.Sprintf("http://fast/%d", i))
+		s.Done("fast")
+	}
+	s.Done("slow")
+	blocked(t, next(s))
+
+	// Acquire, likewise.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Acquire(ctx, "slow"); err != context.DeadlineExceeded {
+		t.Errorf("Acquire(slow) returned %v", err)
+	}
+	if err := s.Acquire(context.Background(), "other"); err != nil {
+		t.Errorf("Acquire(other) returned %v", err)
+	}
+	if got := s.Stats()[1]; got != (HostStats{"other", 0, 1, 0}) {
+		t.Errorf("Stats() for other = %v", got)
+	}
+}
@@ -0,0 +1,70 @@
+// Package crawler provides the parts of a polite web crawler,
+// for the crawl programs of chapter 8.
+package crawler
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"gopl.io/ch5/links"
+)
+
+// A Scope bounds a crawl.  A zero field means no bound.
+type Scope struct {
+	MaxDepth int      // links followed from a seed, which is at depth 0
+	Hosts    []string // patterns of the hosts to crawl, as for path.Match
+	Prefixes []string // prefixes of the URLs to crawl
+}
+
+// Allows reports whether the scope includes the URL rawurl at depth.
+// Only http and https URLs are included.
+func (s *Scope) Allows(rawurl string, depth int) bool {
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return false
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	if len(s.Hosts) > 0 && !matchHost(s.Hosts, strings.ToLower(u.Hostname())) {
+		return false
+	}
+	if len(s.Prefixes) > 0 {
+		for _, prefix := range s.Prefixes {
+			if strings.HasPrefix(rawurl, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// canonical returns a copy of s for comparison with the URLs that
+// canon puts into canonical form: its prefixes are in that form too,
+// and its host patterns are lower case.
+func (s Scope) canonical(canon *links.Canonicalizer) Scope {
+	hosts := make([]string, len(s.Hosts))
+	for i, pattern := range s.Hosts {
+		hosts[i] = strings.ToLower(pattern)
+	}
+	prefixes := make([]string, len(s.Prefixes))
+	for i, prefix := range s.Prefixes {
+		if p, err := canon.Canonical(prefix); err == nil {
+			prefix = p
+		}
+		prefixes[i] = prefix
+	}
+	s.Hosts, s.Prefixes = hosts, prefixes
+	return s
+}
@@ -0,0 +1,45 @@
+package crawler
+
+import "testing"
+
+func TestScope(t *testing.T) {
+	s := &Scope{
+		MaxDepth: 2,
+		Hosts:    []string{"example.com", "*.Example.org"},
+	}
+	for _, test := range []struct {
+		url   string
+		depth int
+		want  bool
+	}{
+		{"http://example.com/", 0, true},
+		{"https://EXAMPLE.com:8443/a/b", 2, true},
+		{"http://example.com/a/b/c", 3, false},
+		{"http://www.example.org/", 1, true},
+		{"http://example.org/", 1, false},
+		{"http://example.net/", 1, false},
+		{"mailto:gopher@example.com", 1, false},
+		{"ftp://example.com/", 1, false},
+		{"http://example.com/%zz", 1, false},
+	} {
+		if got := s.Allows(test.url, test.depth); got != test.want {
+			t.Errorf("Allows(%q, %d) = %t", test.url, test.depth, got)
+		}
+	}
+
+	s = &Scope{Prefixes: []string{"http://example.com/docs/", "http://example.com/blog/"}}
+	for _, test := range []struct {
+		url  string
+		want bool
+	}{
+		{"http://example.com/docs/", true},
+		{"http://example.com/blog/2016/gopl.html", true},
+		{"http://example.com/docs", false},
+		{"http://example.com/", false},
+		{"http://example.net/docs/", false},
+	} {
+		if got := s.Allows(test.url, 100); got != test.want {
+			t.Errorf("Allows(%q) = %t", test.url, got)
+		}
+	}
+}
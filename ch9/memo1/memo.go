@@ -7,6 +7,9 @@
 
 // Package memo provides a concurrency-unsafe
 // memoization of a function of type Func.
+//
+// Errors are cached for good, like values; memo4 and memo5 can expire
+// them, or retry failed calls.
 package memo
 
 // A Memo caches the results of calling a Func.
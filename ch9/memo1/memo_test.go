@@ -20,7 +20,7 @@ func Test(t *testing.T) {
 // NOTE: not concurrency-safe!  Test fails.
 func TestConcurrent(t *testing.T) {
 	m := memo.New(httpGetBody)
-	memotest.Concurrent(t, m)
+	memotest.ConcurrentDuplicates(t, m)
 }
 
 /*
@@ -5,6 +5,9 @@
 
 // Package memo provides a concurrency-safe memoization a function of
 // type Func.  Concurrent requests are serialized by a Mutex.
+//
+// Errors are cached for good, like values; memo4 and memo5 can expire
+// them, or retry failed calls.
 package memo
 
 import "sync"
@@ -6,6 +6,9 @@
 // Package memo provides a concurrency-safe memoization a function of
 // type Func.  Requests for different keys run concurrently.
 // Concurrent requests for the same key result in duplicate work.
+//
+// Errors are cached for good, like values; memo4 and memo5 can expire
+// them, or retry failed calls.
 package memo
 
 import "sync"
@@ -17,7 +17,8 @@ func Test(t *testing.T) {
 	memotest.Sequential(t, m)
 }
 
+// Concurrent requests for the same key result in duplicate work.
 func TestConcurrent(t *testing.T) {
 	m := memo.New(httpGetBody)
-	memotest.Concurrent(t, m)
+	memotest.ConcurrentDuplicates(t, m)
 }
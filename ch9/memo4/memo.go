@@ -7,9 +7,40 @@
 // a function.  Requests for different keys proceed in parallel.
 // Concurrent requests for the same key block until the first completes.
 // This implementation uses a Mutex.
+//
+// A Memo created by NewWithOptions may be bounded in the number of
+// entries it holds or in their total size, in which case the least
+// recently used completed entries are evicted.  Entries whose values
+// are still being computed are never evicted.
+//
+// Entries may also be given a time to live, after which they are
+// computed afresh.  In stale-while-revalidate mode, an expired value
+// is still returned while a single background call refreshes it.
+//
+// Errors are cached like values unless Options say otherwise: they may
+// be given their own time to live, or not be cached at all, and failed
+// calls may be retried with exponential backoff.  All the clients
+// waiting for a value see the outcome of the same sequence of retries.
+//
+// Successful results may be saved in a memostore.Store, from which
+// a new Memo is loaded, so that they survive a restart.
+//
+// Forget, Refresh and Purge invalidate entries explicitly, and may be
+// called while values are being computed.
+//
+// Stats reports hits, misses and other figures; see memostats.Handler
+// for serving them over HTTP.
 package memo
 
-import "sync"
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopl.io/ch9/memostats"
+	"gopl.io/ch9/memostore"
+)
 
 // Func is the type of the function to memoize.
 type Func func(string) (interface{}, error)
@@ -26,18 +57,114 @@ type result struct {
 type entry struct {
 	res   result
 	ready chan struct{} // closed when res is ready
+
+	key  string
+	size int64 // size of res.value, as reported by Options.Size
+
+	// The fields below are guarded by Memo.mu.
+	elem       *list.Element // position in Memo.lru once ready
+	expires    time.Time     // zero if the entry never expires
+	refreshing bool          // a background refresh is running
+}
+
+// Options configure a Memo.  A zero field means no bound,
+// or the default behavior.
+type Options struct {
+	MaxEntries int   // maximum number of completed entries
+	MaxBytes   int64 // maximum total Size of completed values
+
+	// Size reports the size of a value in bytes.
+	// It is required if MaxBytes is set.  It is not called for
+	// the results of failed calls, whose size is 0.
+	Size func(value interface{}) int64
+
+	TTL      time.Duration // lifetime of a successful result
+	ErrorTTL time.Duration // lifetime of an error result; if negative, errors are not cached
+
+	// StaleWhileRevalidate causes an expired successful result to be
+	// returned while a single background call of the Func refreshes it.
+	// If the refresh fails, the old result is kept, and a later request
+	// tries again, no sooner than ErrorTTL if that is positive.
+	StaleWhileRevalidate bool
+
+	// Store, if set, saves each successful result.  NewWithOptions
+	// loads the memo from it; the loaded entries' lifetimes begin
+	// anew.  If the store cannot be loaded, the memo starts empty.
+	// The caller remains responsible for closing the store.
+	Store memostore.Store
+
+	Retry RetryPolicy // retrying of failed calls of the Func
+}
+
+// A RetryPolicy says how often a failed call of the Func is retried.
+// The delay between calls doubles after each failure, and is
+// randomized by up to half to spread out the retries of many keys.
+type RetryPolicy struct {
+	Attempts   int           // maximum calls per computation; 0 means 1
+	Backoff    time.Duration // delay before the first retry
+	MaxBackoff time.Duration // maximum delay; 0 means no maximum
 }
 
 func New(f Func) *Memo {
-	return &Memo{f: f, cache: make(map[string]*entry)}
+	return NewWithOptions(f, Options{})
+}
+
+// NewWithOptions returns a memoization of f bounded by opts.
+func NewWithOptions(f Func, opts Options) *Memo {
+	if opts.MaxBytes > 0 && opts.Size == nil {
+		panic("memo: Options.MaxBytes requires Options.Size")
+	}
+	memo := &Memo{
+		f:     f,
+		opts:  opts,
+		cache: make(map[string]*entry),
+		lru:   list.New(),
+	}
+	if opts.Store != nil {
+		opts.Store.Load(memo.load)
+	}
+	return memo
+}
+
+// load adds a completed entry for a result loaded from the store.
+func (memo *Memo) load(key string, value interface{}) {
+	e := &entry{ready: make(chan struct{}), key: key}
+	e.res.value = value
+	if memo.opts.Size != nil {
+		e.size = memo.opts.Size(value)
+	}
+	close(e.ready)
+
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	if old := memo.cache[key]; old != nil {
+		memo.remove(old)
+	}
+	memo.cache[key] = e
+	memo.add(e)
 }
 
 type Memo struct {
 	f     Func
-	mu    sync.Mutex // guards cache
+	opts  Options
+	stats memostats.Recorder
+
+	// storeMu orders the writes to the store like the changes to
+	// the cache that they follow.  It is acquired before mu.
+	storeMu sync.Mutex
+
+	mu    sync.Mutex // guards the fields below
 	cache map[string]*entry
+	lru   *list.List // completed entries, most recently used first
+	bytes int64      // total size of the entries in lru
 }
 
+// Evictions returns the number of entries evicted so far.
+func (memo *Memo) Evictions() int64 { return memo.stats.Evictions() }
+
+// Stats returns a snapshot of the memo's figures.
+func (memo *Memo) Stats() memostats.Stats { return memo.stats.Stats() }
+
 func (memo *Memo) Get(key string) (value interface{}, err error) {
 	/*
 		获取互斥锁来保护共享变量cache map，
@@ -46,21 +173,39 @@ func (memo *Memo) Get(key string) (value interface{}, err error) {
 	*/
 	memo.mu.Lock()
 	e := memo.cache[key]
+	if e != nil && e.elem != nil && e.expired(time.Now()) {
+		if memo.opts.StaleWhileRevalidate && e.res.err == nil {
+			// Serve the stale value while a single
+			// background call refreshes it.
+			if !e.refreshing {
+				e.refreshing = true
+				go memo.refresh(e)
+			}
+		} else {
+			memo.remove(e)
+			e = nil
+		}
+	}
 	if e == nil {
 		// This is the first request for this key.
 		// This goroutine becomes responsible for computing
 		// the value and broadcasting the ready condition.
 		// 无缓存的 channel 用于同步状态
 		//  申请一个 token
-		e = &entry{ready: make(chan struct{})}
+		memo.stats.Miss()
+		e = &entry{ready: make(chan struct{}), key: key}
 		memo.cache[key] = e
 		memo.mu.Unlock()
 
-		e.res.value, e.res.err = memo.f(key)
-
-		close(e.ready) // broadcast ready condition
+		memo.fill(e) // broadcasts the ready condition
 	} else {
 		// This is a repeat request for this key.
+		if e.elem != nil {
+			memo.stats.Hit()
+			memo.lru.MoveToFront(e.elem)
+		} else {
+			memo.stats.Suppressed()
+		}
 		memo.mu.Unlock()
 
 		/*
@@ -88,3 +233,212 @@ func (memo *Memo) Get(key string) (value interface{}, err error) {
 }
 
 //!-
+
+// Forget discards the entry for key.  If its value is being computed,
+// the clients already waiting for it still receive it, but later
+// requests compute the value afresh.  The value is also deleted from
+// the store, if any.
+func (memo *Memo) Forget(key string) {
+	memo.storeMu.Lock()
+	defer memo.storeMu.Unlock()
+	memo.mu.Lock()
+	if e := memo.cache[key]; e != nil {
+		memo.discard(e)
+	}
+	memo.mu.Unlock()
+	if memo.opts.Store != nil {
+		memo.opts.Store.Delete(key)
+	}
+}
+
+// Refresh recomputes the value of key in the background.  Until the
+// new value is ready, requests receive the current one, if any.
+// If the value is being computed already, Refresh does nothing.
+func (memo *Memo) Refresh(key string) {
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	e := memo.cache[key]
+	switch {
+	case e == nil:
+		e = &entry{ready: make(chan struct{}), key: key}
+		memo.cache[key] = e
+		go memo.fill(e)
+	case e.elem == nil || e.refreshing:
+		// The value is being computed already.
+	default:
+		e.refreshing = true
+		go memo.refresh(e)
+	}
+}
+
+// Purge discards all entries, as if by calling Forget for each key.
+func (memo *Memo) Purge() {
+	memo.storeMu.Lock()
+	defer memo.storeMu.Unlock()
+	memo.mu.Lock()
+	var keys []string
+	for key, e := range memo.cache {
+		keys = append(keys, key)
+		e.elem = nil
+	}
+	memo.cache = make(map[string]*entry)
+	memo.lru.Init()
+	memo.bytes = 0
+	memo.mu.Unlock()
+	if memo.opts.Store != nil {
+		for _, key := range keys {
+			memo.opts.Store.Delete(key)
+		}
+	}
+}
+
+// fill computes the value of the new entry e, adds it to the memo
+// and the store unless it has been forgotten meanwhile, and
+// broadcasts the ready condition.
+func (memo *Memo) fill(e *entry) {
+	memo.compute(e)
+
+	memo.storeMu.Lock()
+	memo.mu.Lock()
+	current := memo.cache[e.key] == e
+	if current && e.res.err != nil && memo.opts.ErrorTTL < 0 {
+		delete(memo.cache, e.key) // the waiters still see the error
+		current = false
+	}
+	if current {
+		memo.add(e)
+	}
+	memo.mu.Unlock()
+	if current {
+		memo.save(e)
+	}
+	memo.storeMu.Unlock()
+
+	close(e.ready) // broadcast ready condition
+}
+
+// refresh computes a new entry to replace the entry old,
+// which is served to clients in the meantime.
+func (memo *Memo) refresh(old *entry) {
+	e := &entry{ready: make(chan struct{}), key: old.key}
+	memo.compute(e)
+	close(e.ready)
+
+	memo.storeMu.Lock()
+	defer memo.storeMu.Unlock()
+	memo.mu.Lock()
+	current := memo.cache[old.key] == old // not evicted or forgotten meanwhile
+	if current && e.res.err != nil {
+		old.refreshing = false // keep the old value; try again later
+		if now := time.Now(); memo.opts.ErrorTTL > 0 && old.expired(now) {
+			old.expires = now.Add(memo.opts.ErrorTTL)
+		}
+		current = false
+	}
+	if current {
+		memo.remove(old)
+		memo.cache[e.key] = e
+		memo.add(e)
+	}
+	memo.mu.Unlock()
+	if current {
+		memo.save(e)
+	}
+}
+
+// compute calls the Func to set e.res, retrying
+// failures as directed by memo.opts.Retry.
+func (memo *Memo) compute(e *entry) {
+	r := memo.opts.Retry
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		done := memo.stats.Call()
+		e.res.value, e.res.err = memo.f(e.key)
+		done(e.res.err)
+		if e.res.err == nil || attempt >= r.Attempts {
+			break
+		}
+		memo.stats.Retry()
+		time.Sleep(jitter(backoff))
+		backoff *= 2
+		if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+	if memo.opts.Size != nil && e.res.err == nil {
+		e.size = memo.opts.Size(e.res.value)
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// save saves a successful result of the completed entry e in the store.
+func (memo *Memo) save(e *entry) {
+	if memo.opts.Store != nil && e.res.err == nil {
+		// A failure to save the result doesn't affect the memo.
+		memo.opts.Store.Put(e.key, e.res.value)
+	}
+}
+
+// expired reports whether the completed entry e has expired by now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// add makes the completed entry e eligible for eviction,
+// then evicts entries until the memo is within its bounds.
+// The caller must hold memo.mu.
+func (memo *Memo) add(e *entry) {
+	ttl := memo.opts.TTL
+	if e.res.err != nil {
+		ttl = memo.opts.ErrorTTL
+	}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	e.elem = memo.lru.PushFront(e)
+	memo.bytes += e.size
+	for memo.overBudget() {
+		memo.evict(memo.lru.Back().Value.(*entry))
+	}
+}
+
+func (memo *Memo) overBudget() bool {
+	if memo.lru.Len() == 0 {
+		return false
+	}
+	return (memo.opts.MaxEntries > 0 && memo.lru.Len() > memo.opts.MaxEntries) ||
+		(memo.opts.MaxBytes > 0 && memo.bytes > memo.opts.MaxBytes)
+}
+
+// evict removes the completed entry e from the memo to make room.
+// The caller must hold memo.mu.
+func (memo *Memo) evict(e *entry) {
+	memo.remove(e)
+	memo.stats.Evicted()
+}
+
+// discard removes the entry e from the memo, whether or not it
+// has completed.  The caller must hold memo.mu.
+func (memo *Memo) discard(e *entry) {
+	if e.elem != nil {
+		memo.remove(e)
+	} else {
+		delete(memo.cache, e.key)
+	}
+}
+
+// remove removes the completed entry e from the memo.
+// The caller must hold memo.mu.
+func (memo *Memo) remove(e *entry) {
+	memo.lru.Remove(e.elem)
+	e.elem = nil
+	memo.bytes -= e.size
+	delete(memo.cache, e.key)
+}
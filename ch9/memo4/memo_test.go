@@ -4,6 +4,7 @@
 package memo_test
 
 import (
+	"context"
 	"testing"
 
 	"gopl.io/ch9/memo4"
@@ -21,3 +22,95 @@ func TestConcurrent(t *testing.T) {
 	m := memo.New(httpGetBody)
 	memotest.Concurrent(t, m)
 }
+
+// unclosed is a memo4 Memo, which needs no Close.
+type unclosed struct{ *memo.Memo }
+
+func (unclosed) Close() {}
+
+func withOptions(f memotest.ContextFunc, opts memotest.Options) memotest.Memo {
+	return unclosed{memo.NewWithOptions(func(key string) (interface{}, error) {
+		return f(context.Background(), key)
+	}, memo.Options{
+		MaxEntries:           opts.MaxEntries,
+		MaxBytes:             opts.MaxBytes,
+		Size:                 opts.Size,
+		TTL:                  opts.TTL,
+		ErrorTTL:             opts.ErrorTTL,
+		StaleWhileRevalidate: opts.StaleWhileRevalidate,
+		Store:                opts.Store,
+		Retry:                memo.RetryPolicy(opts.Retry),
+	})}
+}
+
+func TestMaxEntries(t *testing.T) {
+	memotest.MaxEntries(t, withOptions)
+}
+
+func TestMaxBytes(t *testing.T) {
+	memotest.MaxBytes(t, withOptions)
+}
+
+func TestErrorSize(t *testing.T) {
+	memotest.ErrorSize(t, withOptions)
+}
+
+func TestInFlightNotEvicted(t *testing.T) {
+	memotest.InFlightNotEvicted(t, withOptions)
+}
+
+func TestTTL(t *testing.T) {
+	memotest.TTL(t, withOptions)
+}
+
+func TestErrorTTL(t *testing.T) {
+	memotest.ErrorTTL(t, withOptions)
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	memotest.StaleWhileRevalidate(t, withOptions)
+}
+
+func TestStaleRefreshFails(t *testing.T) {
+	memotest.StaleRefreshFails(t, withOptions)
+}
+
+func TestStore(t *testing.T) {
+	memotest.Store(t, withOptions)
+}
+
+func TestForgetWhileSaving(t *testing.T) {
+	memotest.ForgetWhileSaving(t, withOptions)
+}
+
+func TestStats(t *testing.T) {
+	memotest.Stats(t, withOptions)
+}
+
+func TestForget(t *testing.T) {
+	memotest.Forget(t, withOptions)
+}
+
+func TestForgetInFlight(t *testing.T) {
+	memotest.ForgetInFlight(t, withOptions)
+}
+
+func TestRefresh(t *testing.T) {
+	memotest.Refresh(t, withOptions)
+}
+
+func TestPurge(t *testing.T) {
+	memotest.Purge(t, withOptions)
+}
+
+func TestErrorsNotCached(t *testing.T) {
+	memotest.ErrorsNotCached(t, withOptions)
+}
+
+func TestRetry(t *testing.T) {
+	memotest.Retry(t, withOptions)
+}
+
+func TestRetryExhausted(t *testing.T) {
+	memotest.RetryExhausted(t, withOptions)
+}
@@ -7,12 +7,52 @@
 // of a function.  Requests for different keys proceed in parallel.
 // Concurrent requests for the same key block until the first completes.
 // This implementation uses a monitor goroutine.
+//
+// A client that stops waiting, by cancelling the context passed to
+// GetContext, returns at once.  When every client waiting on a key
+// has gone, the computation of that key is cancelled too and its
+// result is not cached.
+//
+// A Memo created by NewWithOptions may be bounded in the number of
+// entries it holds or in their total size, in which case the least
+// recently used completed entries are evicted.  Entries whose values
+// are still being computed are never evicted.
+//
+// Entries may also be given a time to live, after which they are
+// computed afresh.  In stale-while-revalidate mode, an expired value
+// is still returned while a single background call refreshes it.
+//
+// Errors are cached like values unless Options say otherwise: they may
+// be given their own time to live, or not be cached at all, and failed
+// calls may be retried with exponential backoff.  All the clients
+// waiting for a value see the outcome of the same sequence of retries.
+//
+// Successful results may be saved in a memostore.Store, from which
+// a new Memo is loaded, so that they survive a restart.
+//
+// Forget, Refresh and Purge invalidate entries explicitly, and may be
+// called while values are being computed.
+//
+// Stats reports hits, misses and other figures; see memostats.Handler
+// for serving them over HTTP.
 package memo
 
+import (
+	"container/list"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopl.io/ch9/memostats"
+	"gopl.io/ch9/memostore"
+)
+
 //!+Func
 
 // Func is the type of the function to memoize.
-type Func func(key string) (interface{}, error)
+// The context is cancelled once no client is waiting for the result.
+type Func func(ctx context.Context, key string) (interface{}, error)
 
 // A result is the result of calling a Func.
 type result struct {
@@ -23,39 +63,201 @@ type result struct {
 type entry struct {
 	res   result
 	ready chan struct{} // closed when res is ready
+
+	// The fields below are confined to the monitor goroutine.
+	key     string
+	cancel  context.CancelFunc     // cancels the call of f
+	waiters map[chan<- result]bool // clients still waiting for res
+	size    int64                  // size of res.value, as reported by Options.Size
+	elem    *list.Element          // position in the LRU list once ready
+	expires time.Time              // zero if the entry never expires
+	done    bool                   // the call of f has returned
+	cached  bool                   // res was cached; set before settled is closed
+	settled chan struct{}          // closed once the monitor has handled the result
+
+	refresh  *entry // the running refresh of this expired entry
+	replaces *entry // the expired entry that this one refreshes
 }
 
 //!-Func
 
 //!+get
 
-// A request is a message requesting that the Func be applied to key.
+type requestKind int
+
+const (
+	get     requestKind = iota // apply the Func to key
+	cancel                     // the client no longer waits on response
+	forget                     // discard the entry for key
+	refresh                    // recompute the value of key
+	purge                      // discard all entries
+)
+
+// A request is a message to the monitor goroutine about key.
 type request struct {
+	kind     requestKind
 	key      string
-	response chan<- result // the client wants a single result
+	response chan<- result   // the client wants a single result
+	purged   chan<- []string // the client wants the purged keys
+}
+
+// Options configure a Memo.  A zero field means no bound,
+// or the default behavior.
+type Options struct {
+	MaxEntries int   // maximum number of completed entries
+	MaxBytes   int64 // maximum total Size of completed values
+
+	// Size reports the size of a value in bytes.
+	// It is required if MaxBytes is set.  It is not called for
+	// the results of failed calls, whose size is 0.
+	Size func(value interface{}) int64
+
+	TTL      time.Duration // lifetime of a successful result
+	ErrorTTL time.Duration // lifetime of an error result; if negative, errors are not cached
+
+	// StaleWhileRevalidate causes an expired successful result to be
+	// returned while a single background call of the Func refreshes it.
+	// If the refresh fails, the old result is kept, and a later request
+	// tries again, no sooner than ErrorTTL if that is positive.
+	StaleWhileRevalidate bool
+
+	// Store, if set, saves each successful result.  NewWithOptions
+	// loads the memo from it; the loaded entries' lifetimes begin
+	// anew.  If the store cannot be loaded, the memo starts empty.
+	// The caller remains responsible for closing the store.
+	Store memostore.Store
+
+	Retry RetryPolicy // retrying of failed calls of the Func
+}
+
+// A RetryPolicy says how often a failed call of the Func is retried.
+// The delay between calls doubles after each failure, and is
+// randomized by up to half to spread out the retries of many keys.
+// Retries stop when the call's context is cancelled.
+type RetryPolicy struct {
+	Attempts   int           // maximum calls per computation; 0 means 1
+	Backoff    time.Duration // delay before the first retry
+	MaxBackoff time.Duration // maximum delay; 0 means no maximum
 }
 
 /*
-Memo 结构体，数据时 request 的 channel
+Memo 结构体，数据时 request 的 channel。
+done 用于 call goroutine 通知 monitor 某个条目已经计算完成，
+quit 在 monitor 退出时关闭，避免 call goroutine 永远阻塞在 done 上。
 */
-type Memo struct{ requests chan request }
+type Memo struct {
+	requests chan request
+	done     chan *entry   // entries whose computation has finished
+	quit     chan struct{} // closed when the monitor goroutine exits
+	store    memostore.Store
+	stats    memostats.Recorder
+
+	// storeMu orders the writes to the store like the changes to
+	// the cache that they follow.
+	storeMu sync.Mutex
+}
 
 // New returns a memoization of f.  Clients must subsequently call Close.
 func New(f Func) *Memo {
-	memo := &Memo{requests: make(chan request)}
-	go memo.server(f)
+	return NewWithOptions(f, Options{})
+}
+
+// NewWithOptions returns a memoization of f bounded by opts.
+// Clients must subsequently call Close.
+func NewWithOptions(f Func, opts Options) *Memo {
+	if opts.MaxBytes > 0 && opts.Size == nil {
+		panic("memo: Options.MaxBytes requires Options.Size")
+	}
+	memo := &Memo{
+		requests: make(chan request),
+		done:     make(chan *entry),
+		quit:     make(chan struct{}),
+		store:    opts.Store,
+	}
+	c := &cache{
+		opts:    opts,
+		entries: make(map[string]*entry),
+		waiting: make(map[chan<- result]*entry),
+		lru:     list.New(),
+		stats:   &memo.stats,
+	}
+	if opts.Store != nil {
+		opts.Store.Load(c.load)
+	}
+	go memo.server(f, c)
 	return memo
 }
 
+// Evictions returns the number of entries evicted so far.
+func (memo *Memo) Evictions() int64 { return memo.stats.Evictions() }
+
+// Stats returns a snapshot of the memo's figures.
+func (memo *Memo) Stats() memostats.Stats { return memo.stats.Stats() }
+
+// Get is equivalent to GetContext with a background context.
+func (memo *Memo) Get(key string) (interface{}, error) {
+	return memo.GetContext(context.Background(), key)
+}
+
 /*
-上面的Get方法，会创建一个response channel，把它放进request结构中，
-然后发送给monitor goroutine，然后马上又会接收它。
+GetContext 会创建一个response channel，把它放进request结构中，
+然后发送给monitor goroutine，然后等待结果或者ctx被取消。
+
+response channel 带有一个缓冲，这样即使客户端已经放弃等待，
+deliver goroutine 也能把结果发送出去然后退出，不会泄露。
 */
-func (memo *Memo) Get(key string) (interface{}, error) {
-	response := make(chan result)
-	memo.requests <- request{key, response}
-	res := <-response
-	return res.value, res.err
+
+// GetContext returns the memoized value of key, waiting until it is
+// ready or ctx is done, whichever happens first.  In the latter case
+// it returns ctx.Err().
+func (memo *Memo) GetContext(ctx context.Context, key string) (interface{}, error) {
+	response := make(chan result, 1)
+	select {
+	case memo.requests <- request{kind: get, key: key, response: response}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case res := <-response:
+		return res.value, res.err
+	case <-ctx.Done():
+		memo.requests <- request{kind: cancel, key: key, response: response}
+		return nil, ctx.Err()
+	}
+}
+
+// Forget discards the entry for key.  If its value is being computed,
+// the clients already waiting for it still receive it, but later
+// requests compute the value afresh.  The value is also deleted from
+// the store, if any.
+func (memo *Memo) Forget(key string) {
+	memo.storeMu.Lock()
+	defer memo.storeMu.Unlock()
+	memo.requests <- request{kind: forget, key: key}
+	if memo.store != nil {
+		memo.store.Delete(key)
+	}
+}
+
+// Refresh recomputes the value of key in the background.  Until the
+// new value is ready, requests receive the current one, if any.
+// If the value is being computed already, Refresh does nothing.
+func (memo *Memo) Refresh(key string) {
+	memo.requests <- request{kind: refresh, key: key}
+}
+
+// Purge discards all entries, as if by calling Forget for each key.
+func (memo *Memo) Purge() {
+	memo.storeMu.Lock()
+	defer memo.storeMu.Unlock()
+	purged := make(chan []string)
+	memo.requests <- request{kind: purge, purged: purged}
+	keys := <-purged
+	if memo.store != nil {
+		for _, key := range keys {
+			memo.store.Delete(key)
+		}
+	}
 }
 
 func (memo *Memo) Close() { close(memo.requests) }
@@ -69,34 +271,305 @@ monitor会在循环中一直读取请求，直到request channel被Close方法
 
 每一个请求都会去查询cache，如果没有找到条目的话，那么就会创建/插入一个新的条目。
 */
-func (memo *Memo) server(f Func) {
+func (memo *Memo) server(f Func, c *cache) {
 	// 将 cache 限制在一个协程里面
-	cache := make(map[string]*entry)
-	// 遍历 requests 的 channel
-	for req := range memo.requests {
+	defer close(memo.quit)
+	for {
+		select {
+		case req, ok := <-memo.requests:
+			if !ok {
+				// Release the contexts of computations that are still running.
+				for _, e := range c.entries {
+					e.cancel()
+					if e.refresh != nil {
+						e.refresh.cancel()
+					}
+				}
+				for _, e := range c.waiting {
+					e.cancel() // forgotten, but still running
+				}
+				return
+			}
+			c.handle(memo, f, req)
+
+		case e := <-memo.done:
+			// Only completed entries that are still cached
+			// become candidates for eviction.  A refresh
+			// replaces the old entry, if it is still cached.
+			// Errors may not be cached at all, and never
+			// replace the old value.
+			uncached := e.res.err != nil && c.opts.ErrorTTL < 0
+			switch {
+			case c.entries[e.key] == e && uncached:
+				delete(c.entries, e.key) // the waiters still see the error
+			case c.entries[e.key] == e:
+				c.add(e)
+			case e.replaces != nil && c.entries[e.key] == e.replaces && e.res.err != nil:
+				e.replaces.refresh = nil // keep the old value; try again later
+				if now := time.Now(); c.opts.ErrorTTL > 0 && e.replaces.expired(now) {
+					e.replaces.expires = now.Add(c.opts.ErrorTTL)
+				}
+			case e.replaces != nil && c.entries[e.key] == e.replaces:
+				c.remove(e.replaces)
+				c.entries[e.key] = e
+				c.add(e)
+			}
+			for response := range e.waiters {
+				delete(c.waiting, response)
+			}
+			e.waiters = nil // nobody can abandon a finished entry
+			e.done = true
+			e.cached = c.entries[e.key] == e
+			close(e.settled) // let call broadcast the ready condition
+		}
+	}
+}
+
+// A cache is the state of a Memo, confined to its monitor goroutine.
+type cache struct {
+	opts    Options
+	entries map[string]*entry
+	waiting map[chan<- result]*entry // the entry each waiting client awaits
+	lru     *list.List               // completed entries, most recently used first
+	bytes   int64                    // total size of the entries in lru
+	stats   *memostats.Recorder
+}
+
+func (c *cache) handle(memo *Memo, f Func, req request) {
+	switch req.kind {
+	case get:
 		// 尝试获取对应的 cache
-		e := cache[req.key]
-		if e == nil {
+		e := c.entries[req.key]
+		if e != nil && e.done && e.expired(time.Now()) {
+			if c.opts.StaleWhileRevalidate && e.res.err == nil {
+				// Serve the stale value while a single
+				// background call refreshes it.
+				if e.refresh == nil {
+					e.refresh = c.start(memo, f, req.key)
+					e.refresh.replaces = e
+				}
+			} else {
+				c.remove(e)
+				e = nil
+			}
+		}
+		switch {
+		case e == nil:
 			// This is the first request for this key.
-			// 获取 ready 的 token
-			e = &entry{ready: make(chan struct{})}
-			cache[req.key] = e
-			/*
-				对call和deliver方法的调用必须让它们在自己的goroutine中进行
-				以确保monitor goroutines不会因此而被阻塞住而没法处理新的请求。
-			*/
-			go e.call(f, req.key) // call f(key)
+			c.stats.Miss()
+			e = c.start(memo, f, req.key)
+			c.entries[req.key] = e
+		case e.done:
+			c.stats.Hit()
+		default:
+			c.stats.Suppressed()
+		}
+		if e.elem != nil {
+			c.lru.MoveToFront(e.elem)
+		}
+		if !e.done {
+			e.waiters[req.response] = true
+			c.waiting[req.response] = e
 		}
 		go e.deliver(req.response)
+
+	case cancel:
+		e := c.waiting[req.response]
+		if e == nil {
+			break // stale: the entry has already completed
+		}
+		delete(c.waiting, req.response)
+		delete(e.waiters, req.response)
+		if len(e.waiters) == 0 {
+			// Nobody is waiting any more: abandon the
+			// computation and don't cache its result.
+			e.cancel()
+			if c.entries[e.key] == e {
+				delete(c.entries, e.key)
+			}
+		}
+
+	case forget:
+		if e := c.entries[req.key]; e != nil {
+			c.discard(e)
+		}
+
+	case refresh:
+		e := c.entries[req.key]
+		switch {
+		case e == nil:
+			c.entries[req.key] = c.start(memo, f, req.key)
+		case !e.done || e.refresh != nil:
+			// The value is being computed already.
+		default:
+			e.refresh = c.start(memo, f, req.key)
+			e.refresh.replaces = e
+		}
+
+	case purge:
+		var keys []string
+		for key, e := range c.entries {
+			keys = append(keys, key)
+			c.discard(e)
+		}
+		req.purged <- keys
+	}
+}
+
+// discard removes the entry e from the cache, whether or not it has
+// completed.  The clients waiting for it still receive its result.
+func (c *cache) discard(e *entry) {
+	if e.done {
+		c.remove(e)
+	} else {
+		delete(c.entries, e.key)
+	}
+	if e.refresh != nil {
+		e.refresh.cancel() // nobody else is waiting for it
 	}
 }
 
+// start returns a new entry for key and begins computing its value.
+func (c *cache) start(memo *Memo, f Func, key string) *entry {
+	// 获取 ready 的 token
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &entry{
+		ready:   make(chan struct{}),
+		key:     key,
+		cancel:  cancel,
+		waiters: make(map[chan<- result]bool),
+		settled: make(chan struct{}),
+	}
+	/*
+		对call和deliver方法的调用必须让它们在自己的goroutine中进行
+		以确保monitor goroutines不会因此而被阻塞住而没法处理新的请求。
+	*/
+	go memo.call(ctx, e, f, c.opts) // call f(key)
+	return e
+}
+
+// load adds a completed entry for a result loaded from the store.
+func (c *cache) load(key string, value interface{}) {
+	e := &entry{
+		ready:  make(chan struct{}),
+		key:    key,
+		cancel: func() {}, // there is no computation to cancel
+		done:   true,
+	}
+	e.res.value = value
+	if c.opts.Size != nil {
+		e.size = c.opts.Size(value)
+	}
+	close(e.ready)
+	if old := c.entries[key]; old != nil {
+		c.remove(old)
+	}
+	c.entries[key] = e
+	c.add(e)
+}
+
+// retry calls f, retrying failures as directed by r.
+func (memo *Memo) retry(ctx context.Context, f Func, key string, r RetryPolicy) (interface{}, error) {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		done := memo.stats.Call()
+		value, err := f(ctx, key)
+		done(err)
+		if err == nil || attempt >= r.Attempts || ctx.Err() != nil {
+			return value, err
+		}
+		memo.stats.Retry()
+		timer := time.NewTimer(jitter(backoff))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return value, err
+		}
+		backoff *= 2
+		if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// expired reports whether the completed entry e has expired by now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// add makes the completed entry e eligible for eviction,
+// then evicts entries until the cache is within its bounds.
+func (c *cache) add(e *entry) {
+	ttl := c.opts.TTL
+	if e.res.err != nil {
+		ttl = c.opts.ErrorTTL
+	}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.bytes += e.size
+	for c.overBudget() {
+		c.evict(c.lru.Back().Value.(*entry))
+	}
+}
+
+func (c *cache) overBudget() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	return (c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)
+}
+
+// evict removes the completed entry e from the cache to make room.
+func (c *cache) evict(e *entry) {
+	c.remove(e)
+	c.stats.Evicted()
+}
+
+// remove removes the completed entry e from the cache.
+func (c *cache) remove(e *entry) {
+	c.lru.Remove(e.elem)
+	e.elem = nil
+	c.bytes -= e.size
+	delete(c.entries, e.key)
+}
+
 /*
-entry 的 call 方法
+call 方法：计算 f(key)，交给 monitor 决定是否缓存，然后广播 ready
 */
-func (e *entry) call(f Func, key string) {
-	// Evaluate the function.
-	e.res.value, e.res.err = f(key)
+func (memo *Memo) call(ctx context.Context, e *entry, f Func, opts Options) {
+	// Evaluate the function, retrying failures.
+	e.res.value, e.res.err = memo.retry(ctx, f, e.key, opts.Retry)
+	e.cancel() // release the context's resources
+	if opts.Size != nil && e.res.err == nil {
+		e.size = opts.Size(e.res.value)
+	}
+
+	// Hand the entry to the monitor, and wait until it has
+	// updated the cache, unless the monitor has already gone.
+	memo.storeMu.Lock()
+	select {
+	case memo.done <- e:
+		<-e.settled
+	case <-memo.quit:
+	}
+	// Save a result that was cached, and not abandoned or forgotten.
+	if e.cached && opts.Store != nil && e.res.err == nil {
+		// A failure to save the result doesn't affect the memo.
+		opts.Store.Put(e.key, e.res.value)
+	}
+	memo.storeMu.Unlock()
 	// Broadcast the ready condition.
 	// 归还 token
 	close(e.ready)
@@ -105,7 +578,7 @@ func (e *entry) call(f Func, key string) {
 /*
 entry 的 deliver 方法，用于同步操作
 1. 等待 ready 信号的完成
-2. 将数据写入到 response channel
+2. 将数据写入到 response channel（带缓冲，不会阻塞）
 */
 func (e *entry) deliver(response chan<- result) {
 	// Wait for the ready condition.
@@ -0,0 +1,71 @@
+// Package memo provides a concurrency-safe memoization of a function
+// whose key and value types are type parameters.  Requests for different
+// keys proceed in parallel.  Concurrent requests for the same key block
+// until the first completes.  This implementation uses a Mutex, like
+// gopl.io/ch9/memo4, so callers need neither string keys nor type
+// assertions on the results.
+package memo
+
+import "sync"
+
+// Func is the type of the function to memoize.
+type Func[K comparable, V any] func(key K) (V, error)
+
+type result[V any] struct {
+	value V
+	err   error
+}
+
+type entry[V any] struct {
+	res   result[V]
+	ready chan struct{} // closed when res is ready
+}
+
+// A Memo caches the results of calling a Func.
+type Memo[K comparable, V any] struct {
+	f     Func[K, V]
+	mu    sync.Mutex // guards cache
+	cache map[K]*entry[V]
+}
+
+// New returns a memoization of f.
+func New[K comparable, V any](f Func[K, V]) *Memo[K, V] {
+	return &Memo[K, V]{f: f, cache: make(map[K]*entry[V])}
+}
+
+// Get returns the memoized value of f(key), calling f at most once
+// however many goroutines ask for key concurrently.
+func (memo *Memo[K, V]) Get(key K) (V, error) {
+	memo.mu.Lock()
+	e := memo.cache[key]
+	if e == nil {
+		// This is the first request for this key.
+		// This goroutine becomes responsible for computing
+		// the value and broadcasting the ready condition.
+		e = &entry[V]{ready: make(chan struct{})}
+		memo.cache[key] = e
+		memo.mu.Unlock()
+
+		e.res.value, e.res.err = memo.f(key)
+
+		close(e.ready) // broadcast ready condition
+	} else {
+		// This is a repeat request for this key.
+		memo.mu.Unlock()
+
+		<-e.ready // wait for ready condition
+	}
+	return e.res.value, e.res.err
+}
+
+// Untyped adapts a Memo with string keys to the Get method of the
+// earlier memo packages, whose results are of type interface{},
+// so that it satisfies interfaces such as memotest.M.
+type Untyped[V any] struct {
+	Memo *Memo[string, V]
+}
+
+// Get returns the memoized value of key as an interface{}.
+func (u Untyped[V]) Get(key string) (interface{}, error) {
+	return u.Memo.Get(key)
+}
@@ -0,0 +1,51 @@
+package memo_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"gopl.io/ch9/memo6"
+	"gopl.io/ch9/memotest"
+)
+
+var httpGetBody = memotest.HTTPGetBody
+
+func Test(t *testing.T) {
+	m := memo.New(httpGetBody)
+	memotest.Sequential(t, memo.Untyped[interface{}]{Memo: m})
+}
+
+func TestConcurrent(t *testing.T) {
+	m := memo.New(httpGetBody)
+	memotest.Concurrent(t, memo.Untyped[interface{}]{Memo: m})
+}
+
+type point struct{ x, y int }
+
+func TestTyped(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	m := memo.New(func(p point) (string, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return fmt.Sprintf("(%d,%d)", p.x, p.y), nil
+	})
+
+	var n sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			s, err := m.Get(point{1, 2}) // s is a string: no type assertion
+			if s != "(1,2)" || err != nil {
+				t.Errorf("Get = %q, %v", s, err)
+			}
+		}()
+	}
+	n.Wait()
+	if calls != 1 {
+		t.Errorf("f called %d times, want 1", calls)
+	}
+}
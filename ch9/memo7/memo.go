@@ -0,0 +1,93 @@
+// Package memo provides a concurrency-safe memoization of a function.
+// Requests for different keys proceed in parallel.  Concurrent requests
+// for the same key block until the first completes.
+//
+// This implementation hashes each key to one of several independent
+// shards, each a Mutex-guarded cache like gopl.io/ch9/memo4, so that
+// requests for keys in different shards do not contend for a lock.
+package memo
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Func is the type of the function to memoize.
+type Func func(key string) (interface{}, error)
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+type entry struct {
+	res   result
+	ready chan struct{} // closed when res is ready
+}
+
+// A shard is an independent part of the cache.
+type shard struct {
+	mu    sync.Mutex // guards cache
+	cache map[string]*entry
+}
+
+type Memo struct {
+	f      Func
+	shards []shard
+}
+
+// New returns a memoization of f with one shard per CPU.
+func New(f Func) *Memo {
+	return NewShards(f, runtime.GOMAXPROCS(0))
+}
+
+// NewShards returns a memoization of f with n shards.
+func NewShards(f Func, n int) *Memo {
+	if n < 1 {
+		n = 1
+	}
+	memo := &Memo{f: f, shards: make([]shard, n)}
+	for i := range memo.shards {
+		memo.shards[i].cache = make(map[string]*entry)
+	}
+	return memo
+}
+
+func (memo *Memo) Get(key string) (interface{}, error) {
+	s := &memo.shards[hash(key)%uint32(len(memo.shards))]
+	s.mu.Lock()
+	e := s.cache[key]
+	if e == nil {
+		// This is the first request for this key.
+		// This goroutine becomes responsible for computing
+		// the value and broadcasting the ready condition.
+		e = &entry{ready: make(chan struct{})}
+		s.cache[key] = e
+		s.mu.Unlock()
+
+		e.res.value, e.res.err = memo.f(key)
+
+		close(e.ready) // broadcast ready condition
+	} else {
+		// This is a repeat request for this key.
+		s.mu.Unlock()
+
+		<-e.ready // wait for ready condition
+	}
+	return e.res.value, e.res.err
+}
+
+// hash returns the 32-bit FNV-1a hash of s.
+// It is written out here to avoid allocating a hash.Hash per call.
+func hash(s string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= prime32
+	}
+	return h
+}
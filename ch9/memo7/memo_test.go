@@ -0,0 +1,51 @@
+package memo_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"gopl.io/ch9/memo7"
+	"gopl.io/ch9/memotest"
+)
+
+var httpGetBody = memotest.HTTPGetBody
+
+func Test(t *testing.T) {
+	m := memo.New(httpGetBody)
+	memotest.Sequential(t, m)
+}
+
+func TestConcurrent(t *testing.T) {
+	m := memo.New(httpGetBody)
+	memotest.Concurrent(t, m)
+}
+
+func TestShards(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	m := memo.NewShards(func(key string) (interface{}, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		return key, nil
+	}, 4)
+
+	var n sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i % 10)
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			if v, _ := m.Get(key); v != key {
+				t.Errorf("Get(%q) = %v", key, v)
+			}
+		}()
+	}
+	n.Wait()
+	for key, n := range calls {
+		if n != 1 {
+			t.Errorf("%q computed %d times, want 1", key, n)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package memopeer shares a memo among several processes, in the
+// spirit of groupcache.  Each key is owned by one peer, chosen by
+// consistent hashing.  The owner computes the value, suppressing
+// duplicate calls as gopl.io/ch9/memo4 does; the other peers fetch
+// it from the owner over a simple request/response protocol on TCP
+// connections, and memoize it too.
+//
+// If the owner cannot be reached, a peer computes the value itself,
+// and asks the owner again for later requests.
+package memopeer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gopl.io/ch9/memo4"
+	"gopl.io/ch9/memostore"
+)
+
+// A Peer is one member of a group of processes that share a memo.
+type Peer struct {
+	self  string // the address at which other peers reach this one
+	ring  *ring
+	codec memostore.Codec
+
+	local  *memo.Memo // values of keys owned by this peer
+	remote *memo.Memo // values fetched from their owners
+
+	mu        sync.Mutex // guards the fields below
+	idle      map[string][]net.Conn
+	listeners []net.Listener
+	served    map[net.Conn]bool // connections from other peers
+	closed    bool
+}
+
+// Timeout bounds each exchange with another peer.
+const Timeout = 10 * time.Second
+
+// maxFrame bounds the size of a message, to guard against garbage.
+const maxFrame = 64 << 20
+
+// New returns a Peer whose address is self, in a group whose members
+// have the addresses in peers, which should include self.  The values
+// of f are sent between peers encoded by codec.
+func New(self string, peers []string, f memo.Func, codec memostore.Codec) *Peer {
+	p := &Peer{
+		self:   self,
+		ring:   newRing(peers),
+		codec:  codec,
+		idle:   make(map[string][]net.Conn),
+		served: make(map[net.Conn]bool),
+	}
+	p.local = memo.New(f)
+	// Errors are not cached, so that a peer asks an owner that was
+	// unreachable again next time.
+	p.remote = memo.NewWithOptions(func(key string) (interface{}, error) {
+		return p.fetch(p.ring.owner(key), key)
+	}, memo.Options{ErrorTTL: -1})
+	return p
+}
+
+// Get returns the value of key, from its owner if that is another peer.
+func (p *Peer) Get(key string) (interface{}, error) {
+	if p.ring.owner(key) == p.self {
+		return p.local.Get(key)
+	}
+	value, err := p.remote.Get(key)
+	var ownerErr *peerError
+	if err != nil && !errors.As(err, &ownerErr) {
+		// The owner is unreachable; compute the value here.
+		return p.local.Get(key)
+	}
+	return value, err
+}
+
+// Serve accepts connections from other peers on ln and answers their
+// requests, until ln is closed.
+func (p *Peer) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		ln.Close()
+		return fmt.Errorf("memopeer: Serve after Close")
+	}
+	p.listeners = append(p.listeners, ln)
+	p.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handleConn(conn)
+	}
+}
+
+// Close closes the listeners and the connections of p.
+func (p *Peer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, ln := range p.listeners {
+		ln.Close()
+	}
+	for _, conns := range p.idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	p.idle = nil
+	for conn := range p.served {
+		conn.Close()
+	}
+	return nil
+}
+
+//
+// The protocol.  Each message is a frame: a big-endian uint32 length
+// followed by that many bytes.  A request frame holds a key.  The
+// response frame holds a status byte, then the encoded value or the
+// error message.  A connection carries any number of requests, one
+// at a time.
+//
+
+const (
+	statusOK  = 0
+	statusErr = 1
+)
+
+// A peerError is an error returned by the Func on another peer.
+type peerError struct{ msg string }
+
+func (e *peerError) Error() string { return e.msg }
+
+func (p *Peer) handleConn(conn net.Conn) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.served[conn] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.served, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		key, err := readFrame(r)
+		if err != nil {
+			return // EOF, or a broken connection
+		}
+		resp := []byte{statusOK}
+		value, err := p.local.Get(string(key))
+		if err == nil {
+			var data []byte
+			data, err = p.codec.Encode(value)
+			resp = append(resp, data...)
+		}
+		if err != nil {
+			resp = append([]byte{statusErr}, err.Error()...)
+		}
+		conn.SetWriteDeadline(time.Now().Add(Timeout))
+		if err := writeFrame(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// fetch asks the peer at addr for the value of key.
+func (p *Peer) fetch(addr, key string) (interface{}, error) {
+	conn, pooled, err := p.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.exchange(addr, conn, key)
+	if err != nil && pooled {
+		// The peer may have restarted since the connection was
+		// last used, breaking the others too.  Try a new one.
+		p.drop(addr)
+		if conn, err = net.DialTimeout("tcp", addr, Timeout); err != nil {
+			return nil, err
+		}
+		resp, err = p.exchange(addr, conn, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp[0] == statusErr {
+		return nil, &peerError{string(resp[1:])}
+	}
+	value, err := p.codec.Decode(resp[1:])
+	if err != nil {
+		return nil, &peerError{err.Error()}
+	}
+	return value, nil
+}
+
+// exchange sends the request for key on conn to the peer at addr, and
+// returns the response.  It then returns conn to the idle connections,
+// or closes it if it failed.
+func (p *Peer) exchange(addr string, conn net.Conn, key string) ([]byte, error) {
+	conn.SetDeadline(time.Now().Add(Timeout))
+	if err := writeFrame(conn, []byte(key)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := readFrame(conn)
+	if err != nil || len(resp) == 0 {
+		conn.Close()
+		if err == nil {
+			err = fmt.Errorf("memopeer: empty response from %s", addr)
+		}
+		return nil, err
+	}
+	p.release(addr, conn)
+	return resp, nil
+}
+
+// conn returns an idle connection to addr, or a new one, and whether
+// it was idle.
+func (p *Peer) conn(addr string) (conn net.Conn, pooled bool, err error) {
+	p.mu.Lock()
+	if conns := p.idle[addr]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		p.idle[addr] = conns[:len(conns)-1]
+		p.mu.Unlock()
+		return conn, true, nil
+	}
+	p.mu.Unlock()
+	conn, err = net.DialTimeout("tcp", addr, Timeout)
+	return conn, false, err
+}
+
+// drop closes the idle connections to addr.
+func (p *Peer) drop(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.idle[addr] {
+		conn.Close()
+	}
+	delete(p.idle, addr)
+}
+
+// release returns conn to the idle connections to addr.
+func (p *Peer) release(addr string, conn net.Conn) {
+	conn.SetDeadline(time.Time{})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	p.idle[addr] = append(p.idle[addr], conn)
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if size > maxFrame {
+		return nil, fmt.Errorf("memopeer: frame of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
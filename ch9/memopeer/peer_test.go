@@ -0,0 +1,231 @@
+package memopeer_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"gopl.io/ch9/memopeer"
+	"gopl.io/ch9/memostore"
+)
+
+// group starts n peers on loopback listeners, all computing with f.
+func group(t *testing.T, n int, f func(key string) (interface{}, error)) []*memopeer.Peer {
+	t.Helper()
+	var lns []net.Listener
+	var addrs []string
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+	var peers []*memopeer.Peer
+	for i, ln := range lns {
+		p := memopeer.New(addrs[i], addrs, f, memostore.Bytes)
+		go p.Serve(ln)
+		t.Cleanup(func() { p.Close() })
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// counting returns a Func that records the number of calls per key.
+func counting() (func(key string) (interface{}, error), func(key string) int) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	f := func(key string) (interface{}, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		if key == "bad" {
+			return nil, fmt.Errorf("no value for %s", key)
+		}
+		return []byte("value of " + key), nil
+	}
+	count := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls[key]
+	}
+	return f, count
+}
+
+func TestComputedOnce(t *testing.T) {
+	f, count := counting()
+	peers := group(t, 3, f)
+
+	var keys []string
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		for _, key := range keys {
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(p *memopeer.Peer, key string) {
+					defer wg.Done()
+					value, err := p.Get(key)
+					if err != nil {
+						t.Errorf("Get(%q): %v", key, err)
+						return
+					}
+					if got, want := string(value.([]byte)), "value of "+key; got != want {
+						t.Errorf("Get(%q) = %q, want %q", key, got, want)
+					}
+				}(p, key)
+			}
+		}
+	}
+	wg.Wait()
+	for _, key := range keys {
+		if n := count(key); n != 1 {
+			t.Errorf("%s computed %d times across the group, want 1", key, n)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	f, count := counting()
+	peers := group(t, 3, f)
+	for _, p := range peers {
+		if _, err := p.Get("bad"); err == nil || err.Error() != "no value for bad" {
+			t.Errorf("Get(bad) error = %v, want %q", err, "no value for bad")
+		}
+	}
+	if n := count("bad"); n != 1 {
+		t.Errorf("bad computed %d times, want 1", n)
+	}
+}
+
+func TestOwnerDown(t *testing.T) {
+	f, count := counting()
+	peers := group(t, 2, f)
+	peers[1].Close()
+
+	// Every key is still available from the first peer, which
+	// computes the keys of the second itself.
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if _, err := peers[0].Get(key); err != nil {
+			t.Errorf("Get(%q): %v", key, err)
+		}
+		if n := count(key); n != 1 {
+			t.Errorf("%s computed %d times, want 1", key, n)
+		}
+	}
+}
+
+func TestOwnerBack(t *testing.T) {
+	var addrs []string
+	var lns []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+	value := func(version string) func(key string) (interface{}, error) {
+		return func(key string) (interface{}, error) {
+			return []byte(version + " value of " + key), nil
+		}
+	}
+	first := memopeer.New(addrs[0], addrs, value("first"), memostore.Bytes)
+	go first.Serve(lns[0])
+	defer first.Close()
+	lns[1].Close() // the second peer is down
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if _, err := first.Get(key); err != nil {
+			t.Fatalf("Get(%q) with the owner down: %v", key, err)
+		}
+		keys = append(keys, key)
+	}
+
+	// Once the second peer is back, the first asks it for its keys,
+	// rather than using the values it computed meanwhile.
+	ln, err := net.Listen("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := memopeer.New(addrs[1], addrs, value("second"), memostore.Bytes)
+	go second.Serve(ln)
+	defer second.Close()
+	owned := 0
+	for _, key := range keys {
+		v1, err1 := first.Get(key)
+		v2, err2 := second.Get(key)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("Get(%q): %v, %v", key, err1, err2)
+		}
+		if string(v1.([]byte)) != string(v2.([]byte)) {
+			t.Errorf("Get(%q) = %q from the first peer, %q from the second", key, v1, v2)
+		}
+		if string(v2.([]byte)) == "second value of "+key {
+			owned++
+		}
+	}
+	if owned == 0 {
+		t.Errorf("the second peer owns none of %d keys", len(keys))
+	}
+}
+
+func TestOwnerRestart(t *testing.T) {
+	var addrs []string
+	var lns []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+		addrs = append(addrs, ln.Addr().String())
+	}
+	value := func(version string) func(key string) (interface{}, error) {
+		return func(key string) (interface{}, error) {
+			return []byte(version + " value of " + key), nil
+		}
+	}
+	first := memopeer.New(addrs[0], addrs, value("first"), memostore.Bytes)
+	go first.Serve(lns[0])
+	defer first.Close()
+	second := memopeer.New(addrs[1], addrs, value("second"), memostore.Bytes)
+	go second.Serve(lns[1])
+
+	// Leave the first peer with idle connections to the second.
+	for i := 0; i < 20; i++ {
+		if _, err := first.Get(fmt.Sprintf("old%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second.Close()
+	ln, err := net.Listen("tcp", addrs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := memopeer.New(addrs[1], addrs, value("restarted"), memostore.Bytes)
+	go restarted.Serve(ln)
+	defer restarted.Close()
+
+	// The first peer gets the keys of the restarted peer from it,
+	// despite its broken idle connections.
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("new%d", i)
+		v1, err1 := first.Get(key)
+		v2, err2 := restarted.Get(key)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("Get(%q): %v, %v", key, err1, err2)
+		}
+		if string(v1.([]byte)) != string(v2.([]byte)) {
+			t.Errorf("Get(%q) = %q from the first peer, %q from the restarted one", key, v1, v2)
+		}
+	}
+}
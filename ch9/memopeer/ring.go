@@ -0,0 +1,45 @@
+package memopeer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of points of each peer on the ring.
+// More points spread the keys more evenly.
+const replicas = 50
+
+// A ring is a consistent hash of keys to peers: each peer owns the
+// keys that hash between its points and the preceding points, so
+// adding or removing a peer moves only the keys of that peer.
+type ring struct {
+	points []uint32          // sorted
+	peers  map[uint32]string // peer at each point
+}
+
+func newRing(peers []string) *ring {
+	r := &ring{peers: make(map[uint32]string)}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			r.points = append(r.points, h)
+			r.peers[h] = peer
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the peer that owns key.
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 // wrap around
+	}
+	return r.peers[r.points[i]]
+}
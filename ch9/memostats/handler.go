@@ -0,0 +1,54 @@
+package memostats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// A Source is a memo that reports its Stats.
+type Source interface {
+	Stats() Stats
+}
+
+// Handler returns an http.Handler that serves the Stats of src in
+// the Prometheus text exposition format, with metric names that
+// begin with prefix.
+func Handler(prefix string, src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Write(w, prefix, src.Stats())
+	})
+}
+
+// Write writes s to w in the Prometheus text exposition format.
+func Write(w io.Writer, prefix string, s Stats) {
+	metric := func(name, typ, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", prefix, name, help)
+		fmt.Fprintf(w, "# TYPE %s_%s %s\n", prefix, name, typ)
+		fmt.Fprintf(w, "%s_%s %d\n", prefix, name, value)
+	}
+	metric("hits_total", "counter", "Requests served by a completed entry.", s.Hits)
+	metric("misses_total", "counter", "Requests that started a call of the function.", s.Misses)
+	metric("suppressed_total", "counter", "Requests that waited for a call already in flight.", s.Suppressed)
+	metric("in_flight", "gauge", "Calls of the function now running.", s.InFlight)
+	metric("errors_total", "counter", "Calls of the function that failed.", s.Errors)
+	metric("retries_total", "counter", "Failed calls of the function that were retried.", s.Retries)
+	metric("evictions_total", "counter", "Entries evicted to make room.", s.Evictions)
+
+	name := prefix + "_call_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of calls of the function.\n", name)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	var cumulative int64
+	for i, n := range s.Latency {
+		cumulative += n
+		le := "+Inf"
+		if i < len(buckets) {
+			le = strconv.FormatFloat(buckets[i].Seconds(), 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(s.LatencySum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
@@ -0,0 +1,120 @@
+// Package memostats records how a memo cache behaves and serves the
+// figures in the Prometheus text exposition format, for example
+// alongside the servers of gopl.io/ch7:
+//
+//	m := memo.New(httpGetBody)
+//	http.Handle("/metrics", memostats.Handler("memo", m))
+package memostats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// buckets are the upper bounds of the latency histogram.
+var buckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Buckets returns the upper bounds of the latency histogram.
+func Buckets() []time.Duration { return append([]time.Duration(nil), buckets[:]...) }
+
+// Stats is a snapshot of the figures of a memo.
+type Stats struct {
+	Hits       int64 // requests served by a completed entry
+	Misses     int64 // requests that started a call of the Func
+	Suppressed int64 // requests that waited for another's call
+	InFlight   int64 // calls of the Func now running
+	Errors     int64 // calls of the Func that failed
+	Retries    int64 // failed calls of the Func that were retried
+	Evictions  int64 // entries evicted to make room
+
+	// Latency[i] counts calls that took at most Buckets()[i];
+	// the last element counts the slower ones.
+	Latency    []int64
+	LatencySum time.Duration // total time spent in the Func
+}
+
+// Calls returns the number of completed calls of the Func.
+func (s Stats) Calls() int64 {
+	var n int64
+	for _, c := range s.Latency {
+		n += c
+	}
+	return n
+}
+
+// A Recorder accumulates Stats.  Its methods are safe to call
+// concurrently.  The zero value is ready to use.
+type Recorder struct {
+	hits, misses, suppressed, inFlight, errors, retries, evictions int64
+	latencySum                                                     int64 // nanoseconds
+	latency                                                        [len(buckets) + 1]int64
+}
+
+// Hit records a request served by a completed entry.
+func (r *Recorder) Hit() { atomic.AddInt64(&r.hits, 1) }
+
+// Miss records a request that started a call of the Func.
+func (r *Recorder) Miss() { atomic.AddInt64(&r.misses, 1) }
+
+// Suppressed records a request that waited for another's call.
+func (r *Recorder) Suppressed() { atomic.AddInt64(&r.suppressed, 1) }
+
+// Retry records that a failed call of the Func will be retried.
+func (r *Recorder) Retry() { atomic.AddInt64(&r.retries, 1) }
+
+// Evicted records the eviction of an entry.
+func (r *Recorder) Evicted() { atomic.AddInt64(&r.evictions, 1) }
+
+// Call records the start of a call of the Func.  The caller must
+// call the returned function with the call's error when it is done.
+func (r *Recorder) Call() (done func(err error)) {
+	atomic.AddInt64(&r.inFlight, 1)
+	start := time.Now()
+	return func(err error) {
+		d := time.Since(start)
+		atomic.AddInt64(&r.inFlight, -1)
+		if err != nil {
+			atomic.AddInt64(&r.errors, 1)
+		}
+		atomic.AddInt64(&r.latencySum, int64(d))
+		i := 0
+		for i < len(buckets) && d > buckets[i] {
+			i++
+		}
+		atomic.AddInt64(&r.latency[i], 1)
+	}
+}
+
+// Evictions returns the number of evictions recorded so far.
+func (r *Recorder) Evictions() int64 { return atomic.LoadInt64(&r.evictions) }
+
+// Stats returns a snapshot of the figures recorded so far.
+func (r *Recorder) Stats() Stats {
+	s := Stats{
+		Hits:       atomic.LoadInt64(&r.hits),
+		Misses:     atomic.LoadInt64(&r.misses),
+		Suppressed: atomic.LoadInt64(&r.suppressed),
+		InFlight:   atomic.LoadInt64(&r.inFlight),
+		Errors:     atomic.LoadInt64(&r.errors),
+		Retries:    atomic.LoadInt64(&r.retries),
+		Evictions:  atomic.LoadInt64(&r.evictions),
+		LatencySum: time.Duration(atomic.LoadInt64(&r.latencySum)),
+		Latency:    make([]int64, len(r.latency)),
+	}
+	for i := range s.Latency {
+		s.Latency[i] = atomic.LoadInt64(&r.latency[i])
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package memostats_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopl.io/ch9/memostats"
+)
+
+func TestHandler(t *testing.T) {
+	var r memostats.Recorder
+	r.Miss()
+	r.Hit()
+	r.Hit()
+	r.Suppressed()
+	r.Call()(nil)
+	r.Call()(errors.New("oops"))
+	done := r.Call() // still running
+
+	srv := httptest.NewServer(memostats.Handler("memo", &r))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	done(nil)
+
+	for _, want := range []string{
+		"# TYPE memo_hits_total counter\nmemo_hits_total 2\n",
+		"memo_misses_total 1\n",
+		"memo_suppressed_total 1\n",
+		"memo_in_flight 1\n",
+		"memo_errors_total 1\n",
+		"# TYPE memo_call_duration_seconds histogram\n",
+		`memo_call_duration_seconds_bucket{le="0.005"} 2` + "\n",
+		`memo_call_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"memo_call_duration_seconds_count 2\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("response lacks %q:\n%s", want, body)
+		}
+	}
+	if got := r.Stats().InFlight; got != 0 {
+		t.Errorf("InFlight = %d after all calls returned", got)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	b := memostats.Buckets()
+	b[0] = 0
+	b = append(b, time.Hour)
+	if got := memostats.Buckets(); got[0] == 0 || len(got) == len(b) {
+		t.Errorf("changing the result of Buckets changed the buckets")
+	}
+	var r memostats.Recorder
+	r.Call()(nil)
+	if got := len(r.Stats().Latency); got != len(memostats.Buckets())+1 {
+		t.Errorf("len(Latency) = %d, want %d", got, len(memostats.Buckets())+1)
+	}
+}
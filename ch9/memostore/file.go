@@ -0,0 +1,306 @@
+package memostore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// A FileStore is a Store that appends each result to a log file.
+//
+// Each record is framed by a magic number, the payload length and
+// a CRC-32 checksum of the payload, so that corrupted or partially
+// written records are detected and skipped.  Delete appends a
+// tombstone record.  The log is compacted, by rewriting only the
+// latest value of each live key, whenever obsolete records outnumber
+// live ones.
+type FileStore struct {
+	path  string
+	codec Codec
+
+	mu      sync.Mutex // guards the fields below
+	f       *os.File
+	records int             // records in the log
+	live    map[string]bool // keys whose latest record is a put
+	skipped int             // records skipped as corrupt
+}
+
+const (
+	magic      = 0x6d656d6f // "memo"
+	headerSize = 12         // magic, payload length, checksum
+	maxPayload = 1 << 30
+
+	opPut    = 1
+	opDelete = 2
+
+	// Don't bother compacting small logs.
+	minCompactRecords = 1000
+)
+
+// Open opens the log file at path, creating it if necessary.
+// Values are encoded and decoded by codec.
+func Open(path string, codec Codec) (*FileStore, error) {
+	s := &FileStore{path: path, codec: codec, live: make(map[string]bool)}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	s.skipped = scan(data, func(op byte, key string, value []byte) {
+		s.records++
+		if op == opPut {
+			s.live[key] = true
+		} else {
+			delete(s.live, key)
+		}
+	})
+	s.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if s.skipped > 0 {
+		// Rewrite the log without the bad records, so
+		// that new records don't follow a torn one.
+		if err := s.compact(); err != nil {
+			s.f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Skipped returns the number of corrupt records skipped so far.
+func (s *FileStore) Skipped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped
+}
+
+// Load calls put for the latest value of each key in the log,
+// skipping records that cannot be decoded.
+func (s *FileStore) Load(put func(key string, value interface{})) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, latest, skipped, err := s.read()
+	if err != nil {
+		return err
+	}
+	s.skipped += skipped
+	for _, key := range keys {
+		value, err := s.codec.Decode(latest[key])
+		if err != nil {
+			s.skipped++
+			continue
+		}
+		put(key, value)
+	}
+	return nil
+}
+
+// Put appends a record of the value of key to the log.
+func (s *FileStore) Put(key string, value interface{}) error {
+	data, err := s.codec.Encode(value)
+	if err != nil {
+		return err
+	}
+	return s.append(opPut, key, data)
+}
+
+// Delete appends a tombstone record for key to the log.
+func (s *FileStore) Delete(key string) error {
+	return s.append(opDelete, key, nil)
+}
+
+func (s *FileStore) append(op byte, key string, value []byte) error {
+	rec := record(op, key, value)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return fmt.Errorf("memostore: %s is closed", s.path)
+	}
+	if _, err := s.f.Write(rec); err != nil {
+		return err
+	}
+	s.records++
+	if op == opPut {
+		s.live[key] = true
+	} else {
+		delete(s.live, key)
+	}
+	if s.records >= minCompactRecords && s.records > 2*len(s.live) {
+		return s.compact()
+	}
+	return nil
+}
+
+// read returns the live keys in the log, in order of their first
+// put, their latest values, and the number of corrupt records.
+// The caller must hold s.mu.
+func (s *FileStore) read() (keys []string, latest map[string][]byte, skipped int, err error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var order []string
+	latest = make(map[string][]byte)
+	skipped = scan(data, func(op byte, key string, value []byte) {
+		if op == opDelete {
+			delete(latest, key)
+			return
+		}
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = value
+	})
+	// Drop deleted keys, and duplicates of keys put again after a delete.
+	seen := make(map[string]bool)
+	for _, key := range order {
+		if _, ok := latest[key]; ok && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, latest, skipped, nil
+}
+
+// Compact rewrites the log, keeping only the latest record for each key.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// compact rewrites the log into a temporary file, which
+// then replaces it.  The caller must hold s.mu.
+func (s *FileStore) compact() error {
+	keys, latest, _, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := f.Write(record(opPut, key, latest[key])); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Reopen, since s.f refers to the old file.
+	s.f.Close()
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	s.records = len(keys)
+	return nil
+}
+
+// Close closes the log file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// record returns the framed log record of op for key and value.
+//
+// Layout:
+//
+//	magic    uint32
+//	length   uint32 of the payload
+//	checksum uint32, CRC-32 (IEEE) of the payload
+//	payload  op byte, uvarint key length, key, value
+func record(op byte, key string, value []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(value))
+	payload = append(payload, op)
+	var n [binary.MaxVarintLen64]byte
+	payload = append(payload, n[:binary.PutUvarint(n[:], uint64(len(key)))]...)
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	rec := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(rec[0:], magic)
+	binary.BigEndian.PutUint32(rec[4:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(rec[8:], crc32.ChecksumIEEE(payload))
+	return append(rec, payload...)
+}
+
+// scan calls visit for each valid record in data, in order, and returns
+// the number of corrupt records.  After a bad record it resynchronizes
+// by searching for the next magic number.
+func scan(data []byte, visit func(op byte, key string, value []byte)) (skipped int) {
+	bad := false // within a run of unparseable bytes
+	for len(data) >= headerSize {
+		op, key, value, n, ok := parse(data)
+		if !ok {
+			if !bad {
+				skipped++
+				bad = true
+			}
+			data = data[1:]
+			continue
+		}
+		bad = false
+		visit(op, key, value)
+		data = data[n:]
+	}
+	if len(data) > 0 && !bad {
+		skipped++ // a torn header at the end
+	}
+	return skipped
+}
+
+// parse decodes the record at the start of data,
+// returning its length in bytes.
+func parse(data []byte) (op byte, key string, value []byte, n int, ok bool) {
+	if binary.BigEndian.Uint32(data[0:]) != magic {
+		return 0, "", nil, 0, false
+	}
+	length := binary.BigEndian.Uint32(data[4:])
+	if length > maxPayload || int(length) > len(data)-headerSize {
+		return 0, "", nil, 0, false
+	}
+	payload := data[headerSize : headerSize+int(length)]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[8:]) {
+		return 0, "", nil, 0, false
+	}
+	if len(payload) == 0 || (payload[0] != opPut && payload[0] != opDelete) {
+		return 0, "", nil, 0, false
+	}
+	keyLen, k := binary.Uvarint(payload[1:])
+	if k <= 0 || keyLen > uint64(len(payload)-1-k) {
+		return 0, "", nil, 0, false
+	}
+	start := 1 + k
+	key = string(payload[start : start+int(keyLen)])
+	value = payload[start+int(keyLen):]
+	return payload[0], key, value, headerSize + int(length), true
+}
@@ -0,0 +1,157 @@
+package memostore_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopl.io/ch9/memostore"
+)
+
+// load returns the contents of the store as a map.
+func load(t *testing.T, s memostore.Store) map[string]string {
+	t.Helper()
+	m := make(map[string]string)
+	if err := s.Load(func(key string, value interface{}) {
+		m[key] = string(value.([]byte))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func open(t *testing.T, path string) *memostore.FileStore {
+	t.Helper()
+	s, err := memostore.Open(path, memostore.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s := open(t, path)
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}, {"a", "3"}} {
+		if err := s.Put(kv[0], []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, path)
+	defer s.Close()
+	want := map[string]string{"a": "3", "b": "2"}
+	if got := load(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %v, want %v", got, want)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s := open(t, path)
+	for _, key := range []string{"a", "b", "c"} {
+		s.Put(key, []byte("value of "+key))
+	}
+	s.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recLen := len(data) / 3
+	data[recLen+recLen/2] ^= 0xff           // corrupt the payload of "b"
+	data = append(data, data[:recLen-3]...) // a torn record at the end
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, path)
+	want := map[string]string{"a": "value of a", "c": "value of c"}
+	if got := load(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %v, want %v", got, want)
+	}
+	if n := s.Skipped(); n != 2 {
+		t.Errorf("Skipped() = %d, want 2", n)
+	}
+
+	// New records survive alongside the repaired log.
+	s.Put("d", []byte("value of d"))
+	s.Close()
+	s = open(t, path)
+	defer s.Close()
+	want["d"] = "value of d"
+	if got := load(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after repair, Load = %v, want %v", got, want)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s := open(t, path)
+	defer s.Close()
+	for i := 0; i < 5000; i++ {
+		if err := s.Put(fmt.Sprint(i%10), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without compaction the log would hold 5000 records of ~20 bytes.
+	if info.Size() > 1000*20 {
+		t.Errorf("log is %d bytes; compaction did not happen", info.Size())
+	}
+	got := load(t, s)
+	if len(got) != 10 || got["9"] != "4999" {
+		t.Errorf("Load = %v", got)
+	}
+}
+
+func TestGob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s, err := memostore.Open(path, memostore.Gob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Put("n", 42)
+	s.Put("s", "hello")
+	got := make(map[string]interface{})
+	s.Load(func(key string, value interface{}) { got[key] = value })
+	want := map[string]interface{}{"n": 42, "s": "hello"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %v, want %v", got, want)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	s := open(t, path)
+	s.Put("a", []byte("1"))
+	s.Put("b", []byte("2"))
+	s.Delete("a")
+	s.Put("c", []byte("3"))
+	s.Delete("c")
+	s.Put("c", []byte("4"))
+	s.Close()
+
+	s = open(t, path)
+	defer s.Close()
+	want := map[string]string{"b": "2", "c": "4"}
+	if got := load(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %v, want %v", got, want)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := load(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("after Compact, Load = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+// Package memostore provides storage backends that let the results
+// of a memo outlive the process, so that a restarted program begins
+// with a warm cache.
+package memostore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+)
+
+// A Store persists the completed results of a memo.
+// Its methods must be safe to call concurrently.
+type Store interface {
+	// Load calls put for each stored result, oldest first.
+	Load(put func(key string, value interface{})) error
+
+	// Put records the value of key, replacing any earlier one.
+	Put(key string, value interface{}) error
+
+	// Delete forgets the value of key, if any.
+	Delete(key string) error
+}
+
+// A Codec converts values to and from bytes.
+type Codec interface {
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// Bytes is a Codec for values of type []byte,
+// such as the bodies returned by memotest.HTTPGetBody.
+var Bytes Codec = bytesCodec{}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(value interface{}) ([]byte, error) {
+	b, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("memostore: cannot encode %T as bytes", value)
+	}
+	return b, nil
+}
+
+func (bytesCodec) Decode(data []byte) (interface{}, error) { return data, nil }
+
+// Gob is a Codec that uses encoding/gob.  The concrete types
+// of the values must be registered with gob.Register.
+var Gob Codec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -5,13 +5,18 @@
 
 // Package memotest provides common functions for
 // testing various designs of the memo package.
+//
+// The tests fetch their URLs from a local Server,
+// so they need no network access.
 package memotest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -24,6 +29,9 @@ func httpGetBody(url string) (interface{}, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting %s: %s", url, resp.Status)
+	}
 	return ioutil.ReadAll(resp.Body)
 }
 
@@ -31,19 +39,30 @@ func httpGetBody(url string) (interface{}, error) {
 
 var HTTPGetBody = httpGetBody
 
-func incomingURLs() <-chan string {
+// httpGetBodyContext is like httpGetBody but abandons the
+// request when ctx is cancelled.
+func httpGetBodyContext(ctx context.Context, url string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+var HTTPGetBodyContext = httpGetBodyContext
+
+func incomingURLs(urls []string) <-chan string {
 	ch := make(chan string)
 	go func() {
-		for _, url := range []string{
-			"https://golang.org",
-			"https://godoc.org",
-			"https://play.golang.org",
-			"http://gopl.io",
-			"https://golang.org",
-			"https://godoc.org",
-			"https://play.golang.org",
-			"http://gopl.io",
-		} {
+		for _, url := range urls {
 			ch <- url
 		}
 		close(ch)
@@ -60,21 +79,25 @@ type M interface {
 	m := memo.New(httpGetBody)
 //!-seq
 这个测试是顺序地去做所有的调用的。
+每个 URL 都应该只被请求一次。
 */
 
 func Sequential(t *testing.T, m M) {
+	s := NewServer(DefaultLatency)
+	defer s.Close()
 	//!+seq
-	for url := range incomingURLs() {
+	for url := range incomingURLs(s.URLs()) {
 		start := time.Now()
 		value, err := m.Get(url)
 		if err != nil {
-			log.Print(err)
+			t.Error(err)
 			continue
 		}
 		fmt.Printf("%s, %s, %d bytes\n",
 			url, time.Since(start), len(value.([]byte)))
 	}
 	//!-seq
+	s.checkOnce(t)
 }
 
 /*
@@ -86,9 +109,28 @@ func Sequential(t *testing.T, m M) {
 */
 
 func Concurrent(t *testing.T, m M) {
+	s := NewServer(DefaultLatency)
+	defer s.Close()
+	concurrent(t, m, s)
+	s.checkOnce(t)
+}
+
+// ConcurrentDuplicates is like Concurrent, but only logs the URLs that
+// were fetched more than once.  It is for designs that do not suppress
+// duplicate calls.
+func ConcurrentDuplicates(t *testing.T, m M) {
+	s := NewServer(DefaultLatency)
+	defer s.Close()
+	concurrent(t, m, s)
+	for _, dup := range s.duplicates() {
+		t.Logf("duplicate fetch: %s", dup)
+	}
+}
+
+func concurrent(t *testing.T, m M, s *Server) {
 	//!+conc
 	var n sync.WaitGroup
-	for url := range incomingURLs() {
+	for url := range incomingURLs(s.URLs()) {
 		n.Add(1)
 		go func(url string) {
 			defer n.Done()
@@ -96,7 +138,7 @@ func Concurrent(t *testing.T, m M) {
 			// 数据竞争
 			value, err := m.Get(url)
 			if err != nil {
-				log.Print(err)
+				t.Error(err)
 				return
 			}
 			fmt.Printf("%s, %s, %d bytes\n",
@@ -108,3 +150,186 @@ func Concurrent(t *testing.T, m M) {
 	n.Wait()
 	//!-conc
 }
+
+// Keys returns n distinct keys for use in benchmarks.
+func Keys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+	}
+	return keys
+}
+
+// Identity is a trivial function to memoize, so that
+// benchmarks measure the memo itself.
+func Identity(key string) (interface{}, error) { return key, nil }
+
+// Parallel benchmarks m under parallel load from
+// GOMAXPROCS goroutines that ask for keys in turn.
+func Parallel(b *testing.B, m M, keys []string) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := m.Get(keys[i%len(keys)]); err != nil {
+				b.Error(err)
+			}
+			i++
+		}
+	})
+}
+
+// CM is a memo whose clients may cancel their wait.
+type CM interface {
+	GetContext(ctx context.Context, key string) (interface{}, error)
+	Close()
+}
+
+// A ContextFunc is a context-aware function to memoize.
+type ContextFunc func(ctx context.Context, key string) (interface{}, error)
+
+// blocker is a ContextFunc that blocks until it is released
+// or its context is cancelled.
+type blocker struct {
+	mu        sync.Mutex
+	calls     int
+	cancelled int
+	started   chan string   // receives the key of each call
+	release   chan struct{} // closed to let calls finish
+}
+
+func newBlocker() *blocker {
+	return &blocker{
+		started: make(chan string, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blocker) f(ctx context.Context, key string) (interface{}, error) {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	b.started <- key
+	select {
+	case <-b.release:
+		return key, nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.cancelled++
+		b.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (b *blocker) counts() (calls, cancelled int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls, b.cancelled
+}
+
+// Cancel checks that a client that cancels its wait returns promptly,
+// that the abandoned computation is cancelled and not cached, and
+// that a later request computes the value afresh.
+func Cancel(t *testing.T, newMemo func(f ContextFunc) CM) {
+	b := newBlocker()
+	m := newMemo(b.f)
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := m.GetContext(ctx, "x")
+		done <- err
+	}()
+	<-b.started
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetContext after cancel returned %v, want %v", err, context.Canceled)
+	}
+	waitFor(t, "computation to be cancelled", func() bool {
+		_, cancelled := b.counts()
+		return cancelled == 1
+	})
+
+	close(b.release)
+	value, err := m.GetContext(context.Background(), "x")
+	if value != "x" || err != nil {
+		t.Errorf(`GetContext("x") = %v, %v, want x, nil`, value, err)
+	}
+	if calls, _ := b.counts(); calls != 2 {
+		t.Errorf("got %d calls, want 2 (cancelled result must not be cached)", calls)
+	}
+}
+
+// CancelOneWaiter checks that a computation continues for the
+// remaining clients when only some of them cancel.
+func CancelOneWaiter(t *testing.T, newMemo func(f ContextFunc) CM) {
+	b := newBlocker()
+	m := newMemo(b.f)
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := m.GetContext(ctx, "x")
+		first <- err
+	}()
+	<-b.started
+
+	second := make(chan interface{})
+	go func() {
+		value, _ := m.GetContext(context.Background(), "x")
+		second <- value
+	}()
+	// Give the second client a chance to join before the first leaves.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled GetContext returned %v, want %v", err, context.Canceled)
+	}
+
+	close(b.release)
+	if value := <-second; value != "x" {
+		t.Errorf("remaining client got %v, want x", value)
+	}
+	if calls, cancelled := b.counts(); calls != 1 || cancelled != 0 {
+		t.Errorf("got %d calls, %d cancelled; want 1, 0", calls, cancelled)
+	}
+}
+
+// NoLeaks checks that no goroutines are left behind by clients
+// that cancel, once the memo is closed.
+func NoLeaks(t *testing.T, newMemo func(f ContextFunc) CM) {
+	before := runtime.NumGoroutine()
+
+	b := newBlocker()
+	m := newMemo(b.f)
+	var n sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		n.Add(1)
+		go func(key string) {
+			defer n.Done()
+			defer cancel()
+			m.GetContext(ctx, key)
+		}(fmt.Sprintf("key%d", i%5))
+	}
+	n.Wait()
+	m.Close()
+
+	waitFor(t, "goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,66 @@
+package memotest_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	memo4 "gopl.io/ch9/memo4"
+	memo5 "gopl.io/ch9/memo5"
+	memo7 "gopl.io/ch9/memo7"
+	"gopl.io/ch9/memotest"
+)
+
+// Compare the designs under parallel load:
+//
+//	$ go test -bench=. -cpu=1,4,16 gopl.io/ch9/memotest
+
+var keys = memotest.Keys(1024)
+
+func BenchmarkMemo4(b *testing.B) {
+	m := memo4.New(memotest.Identity)
+	memotest.Parallel(b, m, keys)
+}
+
+func BenchmarkMemo5(b *testing.B) {
+	m := memo5.New(func(_ context.Context, key string) (interface{}, error) {
+		return memotest.Identity(key)
+	})
+	defer m.Close()
+	memotest.Parallel(b, m, keys)
+}
+
+func BenchmarkSharded(b *testing.B) {
+	m := memo7.New(memotest.Identity)
+	memotest.Parallel(b, m, keys)
+}
+
+func TestServer(t *testing.T) {
+	s := memotest.NewServer(10 * time.Millisecond)
+	defer s.Close()
+	s.Fail("/broken")
+
+	url := s.URLs()[0]
+	start := time.Now()
+	body, err := memotest.HTTPGetBody(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("response took %s, want at least 10ms", d)
+	}
+	if path := strings.TrimPrefix(url, s.URL); !bytes.Equal(body.([]byte), memotest.Body(path)) {
+		t.Errorf("unexpected body for %s", url)
+	}
+	if _, err := memotest.HTTPGetBody(s.URL + "/broken"); err == nil {
+		t.Errorf("GET /broken succeeded")
+	}
+	if n := s.Count(url); n != 1 {
+		t.Errorf("Count(%s) = %d, want 1", url, n)
+	}
+	if n := s.Total(); n != 2 {
+		t.Errorf("Total() = %d, want 2", n)
+	}
+}
@@ -0,0 +1,626 @@
+package memotest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopl.io/ch9/memostats"
+	"gopl.io/ch9/memostore"
+)
+
+// The checks below apply to the memos of memo4 and memo5, which
+// share their Options and invalidation methods.  Each package adapts
+// its NewWithOptions to a NewFunc.
+
+// Memo is a memo with Options, statistics and invalidation.
+type Memo interface {
+	Get(key string) (interface{}, error)
+	Forget(key string)
+	Refresh(key string)
+	Purge()
+	Stats() memostats.Stats
+	Evictions() int64
+	Close()
+}
+
+// Options mirror the Options of the memo packages.
+type Options struct {
+	MaxEntries           int
+	MaxBytes             int64
+	Size                 func(value interface{}) int64
+	TTL                  time.Duration
+	ErrorTTL             time.Duration
+	StaleWhileRevalidate bool
+	Store                memostore.Store
+	Retry                RetryPolicy
+}
+
+// RetryPolicy mirrors the RetryPolicy of the memo packages.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// A NewFunc returns a memoization of f configured by opts.
+type NewFunc func(f ContextFunc, opts Options) Memo
+
+// counter returns a ContextFunc that returns its key and
+// records how many times it was called for each key.
+func counter() (ContextFunc, map[string]int) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	return func(_ context.Context, key string) (interface{}, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		return key, nil
+	}, calls
+}
+
+// MaxEntries checks that the least recently used entries are evicted
+// beyond Options.MaxEntries.
+func MaxEntries(t *testing.T, newMemo NewFunc) {
+	f, calls := counter()
+	m := newMemo(f, Options{MaxEntries: 2})
+	defer m.Close()
+	for _, key := range []string{"a", "b", "a", "c", "a", "b"} {
+		m.Get(key)
+	}
+	// "b" was least recently used when "c" arrived,
+	// and "c" when "b" came back.
+	want := map[string]int{"a": 1, "b": 2, "c": 1}
+	for k, n := range want {
+		if calls[k] != n {
+			t.Errorf("%q computed %d times, want %d", k, calls[k], n)
+		}
+	}
+	if got := m.Evictions(); got != 2 {
+		t.Errorf("Evictions() = %d, want 2", got)
+	}
+}
+
+// MaxBytes checks that entries are evicted to keep the total Size of
+// the values within Options.MaxBytes.
+func MaxBytes(t *testing.T, newMemo NewFunc) {
+	f, calls := counter()
+	size := func(v interface{}) int64 { return int64(len(v.(string))) }
+	m := newMemo(f, Options{MaxBytes: 7, Size: size})
+	defer m.Close()
+	for _, key := range []string{"aaa", "bb", "c", "aaa", "dddd", "aaa"} {
+		m.Get(key)
+	}
+	// "dddd" pushes out "bb" and "c"; "aaa" stays.
+	if calls["aaa"] != 1 {
+		t.Errorf(`"aaa" computed %d times, want 1`, calls["aaa"])
+	}
+	if got := m.Evictions(); got != 2 {
+		t.Errorf("Evictions() = %d, want 2", got)
+	}
+}
+
+// ErrorSize checks that Size is not asked for the size of the value
+// of a failed call, and that an error takes no room in the memo.
+func ErrorSize(t *testing.T, newMemo NewFunc) {
+	f := func(_ context.Context, key string) (interface{}, error) {
+		if key == "bad" {
+			return nil, fmt.Errorf("bad key")
+		}
+		return []byte(key), nil
+	}
+	size := func(v interface{}) int64 { return int64(len(v.([]byte))) }
+	m := newMemo(f, Options{MaxBytes: 4, Size: size, ErrorTTL: time.Minute})
+	defer m.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, key := range []string{"bad", "aaaa", "bad"} {
+			m.Get(key)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get of a failed key never returned")
+	}
+	// The cached error leaves room for "aaaa".
+	if got := m.Evictions(); got != 0 {
+		t.Errorf("Evictions() = %d, want 0", got)
+	}
+	if s := m.Stats(); s.Hits != 1 {
+		t.Errorf("Hits = %d, want 1 (the cached error)", s.Hits)
+	}
+}
+
+// InFlightNotEvicted checks that an entry whose value is being
+// computed is not evicted, so that its clients share one call.
+func InFlightNotEvicted(t *testing.T, newMemo NewFunc) {
+	release := make(chan struct{})
+	var slowCalls int32
+	f := func(_ context.Context, key string) (interface{}, error) {
+		if key == "slow" {
+			atomic.AddInt32(&slowCalls, 1)
+			<-release
+		}
+		return key, nil
+	}
+	m := newMemo(f, Options{MaxEntries: 1})
+	defer m.Close()
+
+	var n sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			if v, _ := m.Get("slow"); v != "slow" {
+				t.Errorf(`Get("slow") = %v`, v)
+			}
+		}()
+	}
+	// Churn the cache while "slow" is in flight.
+	for _, key := range []string{"a", "b", "c", "d"} {
+		m.Get(key)
+	}
+	close(release)
+	n.Wait()
+	if n := atomic.LoadInt32(&slowCalls); n != 1 {
+		t.Errorf(`"slow" computed %d times, want 1`, n)
+	}
+}
+
+// TTL checks that a value is computed afresh once it has expired.
+func TTL(t *testing.T, newMemo NewFunc) {
+	f, calls := counter()
+	m := newMemo(f, Options{TTL: 20 * time.Millisecond})
+	defer m.Close()
+	m.Get("a")
+	m.Get("a")
+	time.Sleep(30 * time.Millisecond)
+	m.Get("a")
+	if calls["a"] != 2 {
+		t.Errorf(`"a" computed %d times, want 2`, calls["a"])
+	}
+}
+
+// ErrorTTL checks that errors expire after Options.ErrorTTL while
+// values do not.
+func ErrorTTL(t *testing.T, newMemo NewFunc) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	f := func(_ context.Context, key string) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[key]++
+		if key == "bad" {
+			return nil, fmt.Errorf("bad key")
+		}
+		return key, nil
+	}
+	m := newMemo(f, Options{ErrorTTL: 20 * time.Millisecond})
+	defer m.Close()
+	for i := 0; i < 2; i++ {
+		m.Get("good")
+		if _, err := m.Get("bad"); err == nil {
+			t.Fatal(`Get("bad") succeeded`)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+	if calls["good"] != 1 || calls["bad"] != 2 {
+		t.Errorf("calls = %v, want good:1 bad:2", calls)
+	}
+}
+
+// StaleWhileRevalidate checks that an expired value is served without
+// waiting while a single call refreshes it.
+func StaleWhileRevalidate(t *testing.T, newMemo NewFunc) {
+	var version int32
+	gate := make(chan struct{}, 1)
+	gate <- struct{}{} // let the first call through
+	f := func(ctx context.Context, key string) (interface{}, error) {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return atomic.AddInt32(&version, 1), nil
+	}
+	m := newMemo(f, Options{
+		TTL:                  20 * time.Millisecond,
+		StaleWhileRevalidate: true,
+	})
+	defer m.Close()
+	if v, _ := m.Get("a"); v != int32(1) {
+		t.Fatalf(`Get("a") = %v, want 1`, v)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// While the refresh is held at the gate, every
+	// client gets the stale value without waiting.
+	var n sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			if v, _ := m.Get("a"); v != int32(1) {
+				t.Errorf(`stale Get("a") = %v, want 1`, v)
+			}
+		}()
+	}
+	n.Wait()
+
+	gate <- struct{}{} // let one refresh through
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := m.Get("a"); v == int32(2) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed value never appeared")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v := atomic.LoadInt32(&version); v != 2 {
+		t.Errorf("Func called %d times, want 2", v)
+	}
+}
+
+// StaleRefreshFails checks that a failed refresh keeps the stale value,
+// and that the next refresh waits for Options.ErrorTTL.
+func StaleRefreshFails(t *testing.T, newMemo NewFunc) {
+	var calls int32
+	f := func(_ context.Context, key string) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			return nil, fmt.Errorf("refresh failed")
+		}
+		return key, nil
+	}
+	m := newMemo(f, Options{
+		TTL:                  20 * time.Millisecond,
+		ErrorTTL:             time.Minute,
+		StaleWhileRevalidate: true,
+	})
+	defer m.Close()
+	m.Get("a")
+	time.Sleep(30 * time.Millisecond)
+	m.Get("a") // starts the refresh
+	waitFor(t, "the refresh to fail", func() bool { return m.Stats().Errors == 1 })
+	for i := 0; i < 3; i++ {
+		if v, err := m.Get("a"); v != "a" || err != nil {
+			t.Errorf("Get after a failed refresh = %v, %v, want a, nil", v, err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond) // for any refresh started by mistake
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Func called %d times, want 2", n)
+	}
+}
+
+// Store checks that the values saved in a store are loaded by a new
+// memo, so that they are not computed again.
+func Store(t *testing.T, newMemo NewFunc) {
+	path := filepath.Join(t.TempDir(), "memo.log")
+	var calls int32
+	f := func(_ context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte(key), nil
+	}
+
+	// The first run computes both values, saving them.
+	store, err := memostore.Open(path, memostore.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMemo(f, Options{Store: store})
+	m.Get("a")
+	m.Get("b")
+	m.Close()
+	store.Close()
+
+	// The second run finds them in the store.
+	store, err = memostore.Open(path, memostore.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	m = newMemo(f, Options{Store: store})
+	defer m.Close()
+	for _, key := range []string{"a", "b"} {
+		if v, _ := m.Get(key); string(v.([]byte)) != key {
+			t.Errorf("Get(%q) = %q", key, v)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Func called %d times, want 2", n)
+	}
+}
+
+// ForgetWhileSaving checks that a value forgotten while it is being
+// saved in the store stays deleted from the store.
+func ForgetWhileSaving(t *testing.T, newMemo NewFunc) {
+	path := filepath.Join(t.TempDir(), "memo.log")
+	file, err := memostore.Open(path, memostore.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &gatedStore{file, make(chan string), make(chan struct{})}
+	m := newMemo(func(_ context.Context, key string) (interface{}, error) {
+		return []byte(key), nil
+	}, Options{Store: store})
+
+	got := make(chan struct{})
+	go func() {
+		m.Get("a")
+		close(got)
+	}()
+	<-store.putting
+	forgot := make(chan struct{})
+	go func() {
+		m.Forget("a")
+		close(forgot)
+	}()
+	time.Sleep(10 * time.Millisecond) // give Forget a chance to overtake the Put
+	close(store.release)
+	<-got
+	<-forgot
+	m.Close()
+	file.Close()
+
+	file, err = memostore.Open(path, memostore.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.Load(func(key string, value interface{}) {
+		t.Errorf("store still holds forgotten key %q", key)
+	})
+}
+
+// A gatedStore is a store whose Puts wait until release is closed.
+type gatedStore struct {
+	memostore.Store
+	putting chan string   // receives the key of each Put
+	release chan struct{} // closed to let Puts proceed
+}
+
+func (s *gatedStore) Put(key string, value interface{}) error {
+	s.putting <- key
+	<-s.release
+	return s.Store.Put(key, value)
+}
+
+// Stats checks the hits, misses, suppressed calls, errors and
+// calls in flight reported by Stats.
+func Stats(t *testing.T, newMemo NewFunc) {
+	gate := make(chan struct{})
+	f := func(_ context.Context, key string) (interface{}, error) {
+		<-gate
+		if key == "bad" {
+			return nil, fmt.Errorf("bad key")
+		}
+		return key, nil
+	}
+	m := newMemo(f, Options{})
+	defer m.Close()
+
+	var n sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			m.Get("x")
+		}()
+	}
+	// Wait for all three requests to arrive before letting the call finish.
+	for s := m.Stats(); s.Misses+s.Suppressed < 3; s = m.Stats() {
+		time.Sleep(time.Millisecond)
+	}
+	if s := m.Stats(); s.InFlight != 1 {
+		t.Errorf("InFlight = %d, want 1", s.InFlight)
+	}
+	close(gate)
+	n.Wait()
+	m.Get("x")
+	m.Get("bad")
+
+	s := m.Stats()
+	if s.Hits != 1 || s.Misses != 2 || s.Suppressed != 2 || s.Errors != 1 ||
+		s.InFlight != 0 || s.Calls() != 2 {
+		t.Errorf("Stats() = %+v", s)
+	}
+}
+
+// versioned returns a ContextFunc that waits for a value on gate, then
+// returns how many times it has been called.
+func versioned(gate <-chan struct{}) ContextFunc {
+	var calls int32
+	return func(_ context.Context, key string) (interface{}, error) {
+		<-gate
+		return atomic.AddInt32(&calls, 1), nil
+	}
+}
+
+// always returns a channel from which receives never block.
+func always() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// Forget checks that a forgotten value is computed afresh.
+func Forget(t *testing.T, newMemo NewFunc) {
+	m := newMemo(versioned(always()), Options{})
+	defer m.Close()
+	m.Get("a")
+	m.Forget("a")
+	if v, _ := m.Get("a"); v != int32(2) {
+		t.Errorf("Get after Forget = %v, want 2", v)
+	}
+}
+
+// ForgetInFlight checks that forgetting a value being computed
+// keeps later clients from joining the call, and its result from
+// being cached.
+func ForgetInFlight(t *testing.T, newMemo NewFunc) {
+	gate := make(chan struct{})
+	m := newMemo(versioned(gate), Options{})
+	defer m.Close()
+
+	first := make(chan interface{})
+	go func() {
+		v, _ := m.Get("x")
+		first <- v
+	}()
+	for m.Stats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Forget("x")
+
+	// A new client does not join the forgotten computation.
+	second := make(chan interface{})
+	go func() {
+		v, _ := m.Get("x")
+		second <- v
+	}()
+	for m.Stats().InFlight != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	gate <- struct{}{}
+	gate <- struct{}{}
+	v1, v2 := <-first, <-second
+	if v1 == v2 {
+		t.Fatalf("both clients got %v", v1)
+	}
+	// Only the second computation is cached.
+	if v, _ := m.Get("x"); v != v2 {
+		t.Errorf("Get = %v, want %v", v, v2)
+	}
+}
+
+// Refresh checks that Refresh recomputes a value in the background,
+// once however often it is called meanwhile.
+func Refresh(t *testing.T, newMemo NewFunc) {
+	gate := make(chan struct{}, 1)
+	gate <- struct{}{}
+	m := newMemo(versioned(gate), Options{})
+	defer m.Close()
+	m.Get("a")
+
+	m.Refresh("a")
+	m.Refresh("a") // no effect while the first refresh runs
+	if v, _ := m.Get("a"); v != int32(1) {
+		t.Errorf("Get during Refresh = %v, want 1", v)
+	}
+	gate <- struct{}{}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := m.Get("a"); v == int32(2) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed value never appeared")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := m.Stats(); s.Calls() != 2 {
+		t.Errorf("Func called %d times, want 2", s.Calls())
+	}
+}
+
+// Purge checks that every value is computed afresh after Purge.
+func Purge(t *testing.T, newMemo NewFunc) {
+	f, calls := counter()
+	m := newMemo(f, Options{})
+	defer m.Close()
+	m.Get("a")
+	m.Get("b")
+	m.Purge()
+	m.Get("a")
+	m.Get("b")
+	if calls["a"] != 2 || calls["b"] != 2 {
+		t.Errorf("calls = %v, want a:2 b:2", calls)
+	}
+}
+
+// flaky returns a ContextFunc that fails the first n times it is called
+// and records the number of calls.
+func flaky(n int32, calls *int32) ContextFunc {
+	return func(_ context.Context, key string) (interface{}, error) {
+		if atomic.AddInt32(calls, 1) <= n {
+			return nil, fmt.Errorf("transient failure")
+		}
+		return key, nil
+	}
+}
+
+// ErrorsNotCached checks that a negative Options.ErrorTTL keeps errors
+// out of the cache.
+func ErrorsNotCached(t *testing.T, newMemo NewFunc) {
+	var calls int32
+	m := newMemo(flaky(1, &calls), Options{ErrorTTL: -1})
+	defer m.Close()
+	if _, err := m.Get("a"); err == nil {
+		t.Fatal("first Get succeeded")
+	}
+	if v, err := m.Get("a"); v != "a" || err != nil {
+		t.Errorf("second Get = %v, %v, want a, nil", v, err)
+	}
+}
+
+// Retry checks that a failed call is retried, and that all the
+// clients waiting for it see the outcome of the retries.
+func Retry(t *testing.T, newMemo NewFunc) {
+	var calls int32
+	gate := make(chan struct{})
+	f := flaky(2, &calls)
+	m := newMemo(func(ctx context.Context, key string) (interface{}, error) {
+		<-gate
+		return f(ctx, key)
+	}, Options{
+		Retry: RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	})
+	defer m.Close()
+
+	// All clients see the outcome of the one sequence of retries.
+	var n sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		n.Add(1)
+		go func() {
+			defer n.Done()
+			if v, err := m.Get("a"); v != "a" || err != nil {
+				t.Errorf("Get = %v, %v, want a, nil", v, err)
+			}
+		}()
+	}
+	for s := m.Stats(); s.Misses+s.Suppressed < 5; s = m.Stats() {
+		time.Sleep(time.Millisecond)
+	}
+	close(gate)
+	n.Wait()
+	if s := m.Stats(); calls != 3 || s.Retries != 2 || s.Errors != 2 {
+		t.Errorf("calls = %d, Stats() = %+v; want 3 calls, 2 retries, 2 errors", calls, s)
+	}
+}
+
+// RetryExhausted checks that the error of the final attempt is
+// returned, and cached.
+func RetryExhausted(t *testing.T, newMemo NewFunc) {
+	var calls int32
+	m := newMemo(flaky(100, &calls), Options{
+		Retry: RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	defer m.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := m.Get("a"); err == nil {
+			t.Fatal("Get succeeded")
+		}
+	}
+	// The final error is cached.
+	if calls != 3 {
+		t.Errorf("Func called %d times, want 3", calls)
+	}
+}
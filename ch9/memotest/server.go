@@ -0,0 +1,140 @@
+package memotest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// DefaultLatency is the delay of a Server used by Sequential and
+// Concurrent, long enough that concurrent requests overlap.
+const DefaultLatency = 20 * time.Millisecond
+
+// sites are the paths served by a Server, with the sizes of
+// their bodies, after the web sites that the book's tests fetch.
+var sites = []struct {
+	path string
+	size int
+}{
+	{"/golang.org", 7537},
+	{"/godoc.org", 6878},
+	{"/play.golang.org", 5767},
+	{"/gopl.io", 2856},
+}
+
+// A Server is a local HTTP server that stands in for the web sites
+// fetched by the tests, so that they need no network.  It serves a
+// deterministic body for each path and counts the requests.
+type Server struct {
+	*httptest.Server
+	latency time.Duration
+
+	mu     sync.Mutex
+	counts map[string]int  // requests by path
+	fail   map[string]bool // paths that fail
+}
+
+// NewServer starts a Server that delays each response by latency.
+// The caller must call Close when finished.
+func NewServer(latency time.Duration) *Server {
+	s := &Server{
+		latency: latency,
+		counts:  make(map[string]int),
+		fail:    make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *Server) handle(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.counts[req.URL.Path]++
+	fail := s.fail[req.URL.Path]
+	s.mu.Unlock()
+
+	time.Sleep(s.latency)
+	if fail {
+		http.Error(w, "induced failure", http.StatusInternalServerError)
+		return
+	}
+	w.Write(Body(req.URL.Path))
+}
+
+// Fail causes subsequent requests for path to fail
+// with status 500 Internal Server Error.
+func (s *Server) Fail(path string) {
+	s.mu.Lock()
+	s.fail[path] = true
+	s.mu.Unlock()
+}
+
+// Count returns the number of requests for url.
+func (s *Server) Count(url string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[strings.TrimPrefix(url, s.URL)]
+}
+
+// Total returns the number of requests for all URLs.
+func (s *Server) Total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.counts {
+		n += c
+	}
+	return n
+}
+
+// URLs returns the URLs of the sites served by s, each twice.
+func (s *Server) URLs() []string {
+	var urls []string
+	for i := 0; i < 2; i++ {
+		for _, site := range sites {
+			urls = append(urls, s.URL+site.path)
+		}
+	}
+	return urls
+}
+
+// Body returns the body that a Server serves for path.
+func Body(path string) []byte {
+	size := 1024
+	for _, site := range sites {
+		if site.path == path {
+			size = site.size
+		}
+	}
+	line := []byte("This is " + path + ".\n")
+	return bytes.Repeat(line, size/len(line)+1)[:size]
+}
+
+// duplicates returns the URLs that s served more than once.
+func (s *Server) duplicates() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var dups []string
+	for path, n := range s.counts {
+		if n > 1 {
+			dups = append(dups, fmt.Sprintf("%s%s (%d times)", s.URL, path, n))
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
+
+// checkOnce reports an error for each URL that s did not serve exactly once.
+func (s *Server) checkOnce(t *testing.T) {
+	t.Helper()
+	for _, site := range sites {
+		if n := s.Count(s.URL + site.path); n != 1 {
+			t.Errorf("%s%s fetched %d times, want 1", s.URL, site.path, n)
+		}
+	}
+}
@@ -1,5 +1,8 @@
 module gopl.io
 
-go 1.17
+go 1.18
 
-require golang.org/x/net v0.0.0-20220225172249-27dd8689420f
+require (
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
+	golang.org/x/net v0.0.0-20220225172249-27dd8689420f
+)